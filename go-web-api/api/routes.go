@@ -7,66 +7,147 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/niphawanphoopha/go-web-api/config"
 	"github.com/niphawanphoopha/go-web-api/handlers"
+	"github.com/niphawanphoopha/go-web-api/metrics"
 	"github.com/niphawanphoopha/go-web-api/middleware"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
 )
 
-// SetupRoutes configures all the routes for our API
-func SetupRoutes(cfg *config.Config) http.Handler {
+// SetupRoutes configures all the routes for our API. Each request sees the
+// configuration snapshot that is current when it arrives, so reloads apply
+// to new requests.
+func SetupRoutes(cfg *config.Config, store *storage.Store) http.Handler {
 	// Create a new router
 	router := mux.NewRouter()
 	
 	// Add middleware
+	router.Use(middleware.RequestIDMiddleware)
 	router.Use(middleware.LoggingMiddleware)
 	router.Use(middleware.CorsMiddleware())
 	
-	// Add config to context
+	// Add config and store to context
 	router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx := context.WithValue(r.Context(), "config", cfg)
+			ctx := context.WithValue(r.Context(), "config", config.Current())
+			ctx = context.WithValue(ctx, "store", store)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	})
 	
-	// Health check endpoint
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok"}`))
-	}).Methods("GET")
+	// Health check endpoints. /health is kept for existing probes and
+	// reports liveness only.
+	router.HandleFunc("/livez", handlers.Livez).Methods("GET")
+	router.HandleFunc("/readyz", handlers.Readyz).Methods("GET")
+	router.HandleFunc("/health", handlers.Livez).Methods("GET")
 	
-	// API group
+	// Public signing keys for services that verify our tokens
+	router.HandleFunc("/.well-known/jwks.json", handlers.GetJWKS).Methods("GET")
+	
+	// API group, rate limited per client
 	api := router.PathPrefix("/api").Subrouter()
+	api.Use(middleware.RateLimitMiddleware)
 	
 	// Auth routes (public)
 	auth := api.PathPrefix("/auth").Subrouter()
 	auth.HandleFunc("/register", handlers.Register).Methods("POST")
 	auth.HandleFunc("/login", handlers.Login).Methods("POST")
+	auth.HandleFunc("/refresh", handlers.Refresh).Methods("POST")
+	
+	// Auth routes (authenticated)
+	requireAuth := middleware.AuthMiddleware(cfg)
+	auth.Handle("/logout", requireAuth(http.HandlerFunc(handlers.Logout))).Methods("POST")
+	auth.Handle("/logout-all", requireAuth(http.HandlerFunc(handlers.LogoutAll))).Methods("POST")
+	
+	// Change streams, filtered to what the caller can see. They also take the
+	// token as a query parameter, which browsers need for them.
+	streamAuth := middleware.StreamAuthMiddleware(cfg)
+	api.Handle("/events", streamAuth(http.HandlerFunc(handlers.StreamEvents))).Methods("GET")
+	api.Handle("/ws", streamAuth(http.HandlerFunc(handlers.ServeWS))).Methods("GET")
 	
 	// Protected routes
 	protected := api.PathPrefix("").Subrouter()
-	protected.Use(middleware.AuthMiddleware(cfg))
+	protected.Use(requireAuth)
 	
 	// User routes
 	users := protected.PathPrefix("/users").Subrouter()
 	users.HandleFunc("/me", handlers.GetCurrentUser).Methods("GET")
 	
-	// Admin routes
+	// Admin routes, each guarded by the permission it needs
 	admin := protected.PathPrefix("/admin").Subrouter()
-	admin.Use(middleware.AdminMiddleware)
 	
 	// User management routes
-	admin.HandleFunc("/users", handlers.GetAllUsers).Methods("GET")
-	admin.HandleFunc("/users/{id}", handlers.GetUserByID).Methods("GET")
-	admin.HandleFunc("/users/{id}", handlers.UpdateUser).Methods("PUT")
-	admin.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")
+	admin.Handle("/users", can(models.PermUsersRead, handlers.GetAllUsers)).Methods("GET")
+	admin.Handle("/users/trash", can(models.PermUsersRead, handlers.GetUserTrash)).Methods("GET")
+	admin.Handle("/users/trash/{id}", can(models.PermUsersWrite, handlers.PurgeUser)).Methods("DELETE")
+	admin.Handle("/users/{id}", can(models.PermUsersRead, handlers.GetUserByID)).Methods("GET")
+	admin.Handle("/users/{id}", can(models.PermUsersWrite, handlers.UpdateUser)).Methods("PUT")
+	admin.Handle("/users/{id}", can(models.PermUsersWrite, handlers.PatchUser)).Methods("PATCH")
+	admin.Handle("/users/{id}", can(models.PermUsersWrite, handlers.DeleteUser)).Methods("DELETE")
+	admin.Handle("/users/{id}/role", can(models.PermUsersWrite, handlers.AssignRole)).Methods("PUT")
+	admin.Handle("/users/{id}/restore", can(models.PermUsersWrite, handlers.RestoreUser)).Methods("POST")
+	
+	// Role management routes
+	admin.Handle("/permissions", can(models.PermRolesRead, handlers.GetPermissions)).Methods("GET")
+	admin.Handle("/roles", can(models.PermRolesRead, handlers.GetRoles)).Methods("GET")
+	admin.Handle("/roles", can(models.PermRolesWrite, handlers.CreateRole)).Methods("POST")
+	admin.Handle("/roles/{name}", can(models.PermRolesWrite, handlers.UpdateRole)).Methods("PUT")
+	admin.Handle("/roles/{name}", can(models.PermRolesWrite, handlers.DeleteRole)).Methods("DELETE")
+	
+	// Webhook routes
+	admin.Handle("/webhooks", can(models.PermWebhooksRead, handlers.GetWebhooks)).Methods("GET")
+	admin.Handle("/webhooks", can(models.PermWebhooksWrite, handlers.CreateWebhook)).Methods("POST")
+	admin.Handle("/webhooks/{id}", can(models.PermWebhooksRead, handlers.GetWebhookByID)).Methods("GET")
+	admin.Handle("/webhooks/{id}", can(models.PermWebhooksWrite, handlers.UpdateWebhook)).Methods("PUT")
+	admin.Handle("/webhooks/{id}", can(models.PermWebhooksWrite, handlers.DeleteWebhook)).Methods("DELETE")
+	admin.Handle("/webhooks/{id}/deliveries", can(models.PermWebhooksRead, handlers.GetWebhookDeliveries)).Methods("GET")
+	admin.Handle("/webhooks/{id}/deliveries/{delivery}/redeliver", can(models.PermWebhooksWrite, handlers.RedeliverWebhook)).Methods("POST")
 	
 	// Items routes
 	items := protected.PathPrefix("/items").Subrouter()
-	items.HandleFunc("", handlers.GetItems).Methods("GET")
-	items.HandleFunc("/{id}", handlers.GetItemByID).Methods("GET")
-	items.HandleFunc("", handlers.CreateItem).Methods("POST")
-	items.HandleFunc("/{id}", handlers.UpdateItem).Methods("PUT")
-	items.HandleFunc("/{id}", handlers.DeleteItem).Methods("DELETE")
+	items.Handle("", can(models.PermItemsRead, handlers.GetItems)).Methods("GET")
+	items.Handle("/trash", can(models.PermItemsRead, handlers.GetItemTrash)).Methods("GET")
+	items.Handle("/trash/{id}", can(models.PermItemsAdmin, handlers.PurgeItem)).Methods("DELETE")
+	items.Handle("/{id}", can(models.PermItemsRead, handlers.GetItemByID)).Methods("GET")
+	items.Handle("", can(models.PermItemsWrite, handlers.CreateItem)).Methods("POST")
+	items.Handle("/{id}", can(models.PermItemsWrite, handlers.UpdateItem)).Methods("PUT")
+	items.Handle("/{id}", can(models.PermItemsWrite, handlers.PatchItem)).Methods("PATCH")
+	items.Handle("/{id}", can(models.PermItemsWrite, handlers.DeleteItem)).Methods("DELETE")
+	items.Handle("/{id}/restore", can(models.PermItemsWrite, handlers.RestoreItem)).Methods("POST")
+	items.Handle("/{id}/move", can(models.PermItemsWrite, handlers.MoveItem)).Methods("POST")
+	items.Handle("/{id}/move-back", can(models.PermItemsWrite, handlers.MoveItemBack)).Methods("POST")
 	
+	// Todo routes
+	todos := protected.PathPrefix("/todos").Subrouter()
+	todos.Handle("", can(models.PermTodosRead, handlers.GetTodos)).Methods("GET")
+	todos.Handle("/board", can(models.PermTodosRead, handlers.GetTodoBoard)).Methods("GET")
+	todos.Handle("/{id}", can(models.PermTodosRead, handlers.GetTodoByID)).Methods("GET")
+	todos.Handle("/{id}/transitions", can(models.PermTodosRead, handlers.GetTodoTransitions)).Methods("GET")
+	todos.Handle("", can(models.PermTodosWrite, handlers.CreateTodo)).Methods("POST")
+	todos.Handle("/{id}", can(models.PermTodosWrite, handlers.UpdateTodo)).Methods("PUT")
+	todos.Handle("/{id}", can(models.PermTodosWrite, handlers.DeleteTodo)).Methods("DELETE")
+	todos.Handle("/{id}/move", can(models.PermTodosWrite, handlers.MoveTodo)).Methods("POST")
+	todos.Handle("/{id}/move-back", can(models.PermTodosWrite, handlers.MoveTodoBack)).Methods("POST")
+	
+	// Todo column routes
+	todoTypes := protected.PathPrefix("/todo-types").Subrouter()
+	todoTypes.Handle("", can(models.PermTodosRead, handlers.GetTodoTypes)).Methods("GET")
+	todoTypes.Handle("", can(models.PermTodosAdmin, handlers.CreateTodoType)).Methods("POST")
+	todoTypes.Handle("/{name}", can(models.PermTodosAdmin, handlers.UpdateTodoType)).Methods("PUT")
+	todoTypes.Handle("/{name}", can(models.PermTodosAdmin, handlers.DeleteTodoType)).Methods("DELETE")
+	
+	// Count every request, including those matching no route
+	return middleware.MetricsMiddleware(router)
+}
+
+// SetupMetricsRoutes configures the routes of the metrics server, which
+// listens on its own address so the metrics stay off the public API
+func SetupMetricsRoutes() http.Handler {
+	router := mux.NewRouter()
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
 	return router
+}
+
+// can wraps a handler so it requires the named permission
+func can(permission string, handler http.HandlerFunc) http.Handler {
+	return middleware.RequirePermission(permission)(handler)
 } 
\ No newline at end of file
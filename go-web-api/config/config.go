@@ -2,82 +2,206 @@ package config
 
 import (
 	"fmt"
-	"os"
-	"strconv"
+	"strings"
 )
 
 // Config holds all configuration for the API
 type Config struct {
-	Port         int
-	Host         string
-	ReadTimeout  int
-	WriteTimeout int
-	Debug        bool
+	File string // the config file that was loaded, if any
+	
+	Env                string // "development" or "production"
+	Port               int
+	Host               string
+	ReadTimeout        int
+	WriteTimeout       int
+	Debug              bool   // log at debug level, whatever LOG_LEVEL is
+	LogLevel           string // "debug", "info", "warn" or "error"
+	LogFormat          string // "text" or "json"
+	HealthCheckTimeout int    // in seconds, for each readiness check
+	CORSOrigins        string // comma-separated origins allowed to call the API, "*" for any
+	RateLimit          int    // requests per minute per client IP, 0 for no limit
+	RateLimitBurst     int    // requests a client can make at once before being limited
+	MetricsAddr        string // address serving /metrics apart from the API, empty to not serve it
 	
 	// Database configuration
-	DBHost     string
-	DBPort     int
-	DBUser     string
-	DBPassword string
-	DBName     string
-	DBSSLMode  string
+	DBDriver      string // "postgres" or "sqlite"
+	DBPath        string // SQLite database file, or ":memory:"
+	DBHost        string
+	DBPort        int
+	DBUser        string
+	DBPassword    *Secret
+	DBName        string
+	DBSSLMode     string
+	DBAutoMigrate bool // apply pending migrations on startup
+	
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnectAttempts int // connection attempts at startup before giving up
+	DBConnectBackoff  int // in seconds, doubled after every failed attempt
 	
 	// JWT configuration
-	JWTSecret string
-	JWTExpiry int // in minutes
+	JWTSecret          *Secret
+	JWTExpiry          int    // in minutes
+	RefreshTokenExpiry int    // in hours
+	JWTKeysDir         string // directory of PEM keys, empty to sign with JWTSecret
+	JWTActiveKeyID     string // ID of the key used to sign new tokens
+	
+	// Pagination configuration
+	CursorSecret *Secret // signs pagination cursors, random for the process when empty
+	
+	// Trash configuration
+	TrashRetentionDays int // 0 keeps trashed rows forever
+	TrashPurgeInterval int // in minutes
+	
+	// Item expiry configuration
+	ItemMoveTTL        int // in seconds
+	ItemExpiryInterval int // in seconds
+	
+	// Event stream configuration
+	EventLogSize   int // number of events kept for resuming streams
+	EventHeartbeat int // in seconds
+	
+	// Webhook configuration
+	WebhookInterval     int // in seconds
+	WebhookTimeout      int // in seconds
+	WebhookMaxAttempts  int
+	WebhookRetryBackoff int // in seconds, doubled after every failed attempt
+	
+	// Outbox configuration
+	OutboxInterval  int // in seconds
+	OutboxRetention int // in hours
+	
+	// Storage configuration
+	Storage string // "database" or "memory"
+	
+	// Secrets configuration
+	SecretsProvider        string // "none", "file" or "vault"
+	SecretsDir             string // directory of secret files for the file provider
+	SecretsVault           string // encrypted vault file for the vault provider
+	SecretsVaultKey        *Secret
+	SecretsRefreshInterval int // in seconds, 0 to never re-read secrets
+}
+
+// DefaultJWTSecret is the JWT secret used when none is configured. It is
+// public, so the API refuses to start with it in production.
+const DefaultJWTSecret = "your-secret-key"
+
+// setting is one configuration key. Key is its environment variable; config
+// files use it in lowercase and flags in lowercase with dashes. Secrets can
+// also be read from the file named by Key with a _FILE suffix.
+type setting struct {
+	Key     string
+	Value   interface{} // pointer to the Config field
+	Default interface{}
 }
 
-// New returns a new Config struct
-func New() *Config {
-	return &Config{
-		Port:         getEnvAsInt("PORT", 8080),
-		Host:         getEnv("HOST", "0.0.0.0"),
-		ReadTimeout:  getEnvAsInt("READ_TIMEOUT", 10),
-		WriteTimeout: getEnvAsInt("WRITE_TIMEOUT", 10),
-		Debug:        getEnvAsBool("DEBUG", false),
+// settings lists every configuration key with its Config field and default
+func (c *Config) settings() []setting {
+	return []setting{
+		{"APP_ENV", &c.Env, "development"},
+		{"PORT", &c.Port, 8080},
+		{"HOST", &c.Host, "0.0.0.0"},
+		{"READ_TIMEOUT", &c.ReadTimeout, 10},
+		{"WRITE_TIMEOUT", &c.WriteTimeout, 10},
+		{"DEBUG", &c.Debug, false},
+		{"LOG_LEVEL", &c.LogLevel, "info"},
+		{"LOG_FORMAT", &c.LogFormat, "text"},
+		{"HEALTH_CHECK_TIMEOUT", &c.HealthCheckTimeout, 2}, // 2 seconds default
+		{"CORS_ORIGINS", &c.CORSOrigins, "*"},
+		{"RATE_LIMIT", &c.RateLimit, 0},
+		{"RATE_LIMIT_BURST", &c.RateLimitBurst, 20},
+		{"METRICS_ADDR", &c.MetricsAddr, ""},
 		
 		// Database configuration
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnvAsInt("DB_PORT", 5432),
-		DBUser:     getEnv("DB_USER", "postgres"),
-		DBPassword: getEnv("DB_PASSWORD", "postgres"),
-		DBName:     getEnv("DB_NAME", "go_web_api"),
-		DBSSLMode:  getEnv("DB_SSLMODE", "disable"),
+		{"DB_DRIVER", &c.DBDriver, "postgres"},
+		{"DB_PATH", &c.DBPath, "go_web_api.db"},
+		{"DB_HOST", &c.DBHost, "localhost"},
+		{"DB_PORT", &c.DBPort, 5432},
+		{"DB_USER", &c.DBUser, "postgres"},
+		{"DB_PASSWORD", &c.DBPassword, "postgres"},
+		{"DB_NAME", &c.DBName, "go_web_api"},
+		{"DB_SSLMODE", &c.DBSSLMode, "disable"},
+		{"DB_AUTO_MIGRATE", &c.DBAutoMigrate, false},
+		{"DB_MAX_OPEN_CONNS", &c.DBMaxOpenConns, 100},
+		{"DB_MAX_IDLE_CONNS", &c.DBMaxIdleConns, 10},
+		{"DB_CONNECT_ATTEMPTS", &c.DBConnectAttempts, 10},
+		{"DB_CONNECT_BACKOFF", &c.DBConnectBackoff, 1}, // 1 second default
 		
 		// JWT configuration
-		JWTSecret: getEnv("JWT_SECRET", "your-secret-key"),
-		JWTExpiry: getEnvAsInt("JWT_EXPIRY", 60), // 60 minutes default
+		{"JWT_SECRET", &c.JWTSecret, DefaultJWTSecret},
+		{"JWT_EXPIRY", &c.JWTExpiry, 60},                     // 60 minutes default
+		{"REFRESH_TOKEN_EXPIRY", &c.RefreshTokenExpiry, 720}, // 30 days default
+		{"JWT_KEYS_DIR", &c.JWTKeysDir, ""},
+		{"JWT_ACTIVE_KEY_ID", &c.JWTActiveKeyID, ""},
+		
+		// Pagination configuration
+		{"CURSOR_SECRET", &c.CursorSecret, ""},
+		
+		// Trash configuration
+		{"TRASH_RETENTION_DAYS", &c.TrashRetentionDays, 30}, // 30 days default
+		{"TRASH_PURGE_INTERVAL", &c.TrashPurgeInterval, 60}, // hourly default
+		
+		// Item expiry configuration
+		{"ITEM_MOVE_TTL", &c.ItemMoveTTL, 5},               // 5 seconds default
+		{"ITEM_EXPIRY_INTERVAL", &c.ItemExpiryInterval, 1}, // every second default
+		
+		// Event stream configuration
+		{"EVENT_LOG_SIZE", &c.EventLogSize, 1000},
+		{"EVENT_HEARTBEAT", &c.EventHeartbeat, 15}, // 15 seconds default
+		
+		// Webhook configuration
+		{"WEBHOOK_INTERVAL", &c.WebhookInterval, 1},           // every second default
+		{"WEBHOOK_TIMEOUT", &c.WebhookTimeout, 10},            // 10 seconds default
+		{"WEBHOOK_MAX_ATTEMPTS", &c.WebhookMaxAttempts, 8},    // 8 attempts default
+		{"WEBHOOK_RETRY_BACKOFF", &c.WebhookRetryBackoff, 30}, // 30 seconds default
+		
+		// Outbox configuration
+		{"OUTBOX_INTERVAL", &c.OutboxInterval, 1},    // every second default
+		{"OUTBOX_RETENTION", &c.OutboxRetention, 24}, // 24 hours default
+		
+		// Storage configuration
+		{"STORAGE", &c.Storage, "database"},
+		
+		// Secrets configuration
+		{"SECRETS_PROVIDER", &c.SecretsProvider, "none"},
+		{"SECRETS_DIR", &c.SecretsDir, "/run/secrets"},
+		{"SECRETS_VAULT", &c.SecretsVault, "secrets.vault"},
+		{"SECRETS_VAULT_KEY", &c.SecretsVaultKey, ""},
+		{"SECRETS_REFRESH_INTERVAL", &c.SecretsRefreshInterval, 60}, // every minute default
 	}
 }
 
-// GetDBConnString returns the PostgreSQL connection string
-func (c *Config) GetDBConnString() string {
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		c.DBHost, c.DBPort, c.DBUser, c.DBPassword, c.DBName, c.DBSSLMode)
-}
-
-// Helper function to get an environment variable with a default value
-func getEnv(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// Default returns a Config with the default value of every setting
+func Default() *Config {
+	c := &Config{}
+	for _, s := range c.settings() {
+		switch value := s.Value.(type) {
+		case *string:
+			*value = s.Default.(string)
+		case *int:
+			*value = s.Default.(int)
+		case *bool:
+			*value = s.Default.(bool)
+		case **Secret:
+			*value = NewSecret(s.Default.(string))
+		}
 	}
-	return defaultValue
+	return c
 }
 
-// Helper function to get an environment variable as integer with a default value
-func getEnvAsInt(key string, defaultValue int) int {
-	valueStr := getEnv(key, "")
-	if value, err := strconv.Atoi(valueStr); err == nil {
-		return value
+// AllowedOrigins returns the origins in CORSOrigins
+func (c *Config) AllowedOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(c.CORSOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
 	}
-	return defaultValue
+	return origins
 }
 
-// Helper function to get an environment variable as boolean with a default value
-func getEnvAsBool(key string, defaultValue bool) bool {
-	valueStr := getEnv(key, "")
-	if value, err := strconv.ParseBool(valueStr); err == nil {
-		return value
-	}
-	return defaultValue
-} 
\ No newline at end of file
+// GetDBConnString returns the PostgreSQL connection string
+func (c *Config) GetDBConnString() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		c.DBHost, c.DBPort, c.DBUser, c.DBPassword.Value(), c.DBName, c.DBSSLMode)
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from, in rising priority, the defaults, a
+// YAML or TOML config file, environment variables and command-line flags,
+// and validates it. The file is named by the -config flag or CONFIG_FILE.
+// Secrets not given as environment variables or flags are read from their
+// *_FILE variable or else the secret provider, which beat the config file.
+// It returns the arguments left after the flags.
+func Load(args []string) (*Config, []string, error) {
+	c := Default()
+
+	// Parse the flags first to find the config file, but apply them last
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file")
+	flags := map[string]string{}
+	for _, s := range c.settings() {
+		fs.Var(&flagValue{key: s.Key, flags: flags, isBool: isBool(s)}, flagName(s.Key), "")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return nil, nil, err
+		}
+		c.File = *configFile
+	}
+
+	// explicit holds the secrets given as values, which no provider overrides
+	explicit := map[string]bool{}
+	for _, s := range c.settings() {
+		value, ok := os.LookupEnv(s.Key)
+		if secret, isSecret := s.Value.(**Secret); isSecret {
+			if path, fromFile := os.LookupEnv(s.Key + "_FILE"); fromFile {
+				if ok {
+					return nil, nil, fmt.Errorf("environment variables %s and %s_FILE are both set", s.Key, s.Key)
+				}
+				if err := (*secret).load(func() (string, error) { return readSecretFile(path) }); err != nil {
+					return nil, nil, fmt.Errorf("environment variable %s_FILE: %v", s.Key, err)
+				}
+				explicit[s.Key] = true
+				continue
+			}
+		}
+		if ok {
+			if err := set(s, value); err != nil {
+				return nil, nil, fmt.Errorf("environment variable %s: %v", s.Key, err)
+			}
+			explicit[s.Key] = true
+		}
+	}
+
+	for _, s := range c.settings() {
+		if value, ok := flags[s.Key]; ok {
+			if err := set(s, value); err != nil {
+				return nil, nil, fmt.Errorf("flag -%s: %v", flagName(s.Key), err)
+			}
+			explicit[s.Key] = true
+		}
+	}
+
+	if err := c.loadSecrets(explicit); err != nil {
+		return nil, nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return c, fs.Args(), nil
+}
+
+// loadSecrets looks up the secrets that were not given explicitly in the
+// secret provider. The vault key cannot come from the vault it opens.
+func (c *Config) loadSecrets(explicit map[string]bool) error {
+	provider, err := NewSecretProvider(c)
+	if err != nil || provider == nil {
+		return err
+	}
+	for _, s := range c.settings() {
+		secret, ok := s.Value.(**Secret)
+		if !ok || explicit[s.Key] || s.Key == "SECRETS_VAULT_KEY" {
+			continue
+		}
+		key := s.Key
+		err := (*secret).load(func() (string, error) { return provider.Secret(key) })
+		if err != nil && err != ErrSecretNotFound {
+			return fmt.Errorf("secret %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// RefreshSecrets re-reads the secrets loaded from files and the secret
+// provider, so rotated secrets are used without a restart, and returns the
+// keys of those that changed. A secret that cannot be read keeps its value.
+func (c *Config) RefreshSecrets() ([]string, error) {
+	var changed []string
+	var errs []error
+	for _, s := range c.settings() {
+		secret, ok := s.Value.(**Secret)
+		if !ok {
+			continue
+		}
+		updated, err := (*secret).refresh()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("secret %s: %v", s.Key, err))
+			continue
+		}
+		if updated {
+			changed = append(changed, s.Key)
+		}
+	}
+	return changed, errors.Join(errs...)
+}
+
+// loadFile applies the settings in a YAML or TOML file, chosen by its
+// extension. Keys are the environment variable names in lowercase, and
+// unknown keys and values of the wrong type are errors.
+func (c *Config) loadFile(path string) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %v", err)
+	}
+
+	values := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(body, &values)
+	case ".toml":
+		err = toml.NewDecoder(bytes.NewReader(body)).Decode(&values)
+	default:
+		return fmt.Errorf("config file %s: unknown format %q, expected .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	settings := map[string]setting{}
+	for _, s := range c.settings() {
+		settings[strings.ToLower(s.Key)] = s
+	}
+	for key, value := range values {
+		s, ok := settings[key]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown key %q", path, key)
+		}
+		if err := setFileValue(s, value); err != nil {
+			return fmt.Errorf("config file %s: key %q: %v", path, key, err)
+		}
+	}
+	return nil
+}
+
+// setFileValue stores a value decoded from a config file in a setting. The
+// value must already have the setting's type, so "8080" is not a port.
+func setFileValue(s setting, value interface{}) error {
+	switch field := s.Value.(type) {
+	case *string:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %s", describe(value))
+		}
+		*field = v
+	case *int:
+		switch v := value.(type) {
+		case int:
+			*field = v
+		case int64:
+			*field = int(v)
+		default:
+			return fmt.Errorf("expected an integer, got %s", describe(value))
+		}
+	case *bool:
+		v, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected true or false, got %s", describe(value))
+		}
+		*field = v
+	case **Secret:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %s", describe(value))
+		}
+		(*field).Set(v)
+	}
+	return nil
+}
+
+// describe formats a decoded config file value for an error message
+func describe(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case map[string]interface{}:
+		return "a table"
+	case []interface{}:
+		return "a list"
+	case nil:
+		return "nothing"
+	}
+	return fmt.Sprint(value)
+}
+
+// set parses a value from the environment or a flag into a setting
+func set(s setting, value string) error {
+	switch field := s.Value.(type) {
+	case *string:
+		*field = value
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*field = v
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*field = v
+	case **Secret:
+		(*field).Set(value)
+	}
+	return nil
+}
+
+// flagName returns the flag for an environment variable, e.g. db-host for
+// DB_HOST
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// isBool reports whether a setting is a boolean, so its flag needs no value
+func isBool(s setting) bool {
+	_, ok := s.Value.(*bool)
+	return ok
+}
+
+// flagValue records a flag's raw value, to be parsed after the lower layers
+// are applied
+type flagValue struct {
+	key    string
+	flags  map[string]string
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil || f.flags == nil {
+		return ""
+	}
+	return f.flags[f.key]
+}
+
+func (f *flagValue) Set(value string) error {
+	f.flags[f.key] = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
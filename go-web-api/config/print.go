@@ -0,0 +1,41 @@
+package config
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WriteYAML writes every setting as a YAML config file that Load accepts, in
+// the order of the settings table. With redact, the secrets that are set are
+// written as Redacted.
+func (c *Config) WriteYAML(w io.Writer, redact bool) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, s := range c.settings() {
+		value := &yaml.Node{Kind: yaml.ScalarNode}
+		switch field := s.Value.(type) {
+		case *string:
+			value.Tag, value.Value = "!!str", *field
+		case *int:
+			value.Tag, value.Value = "!!int", strconv.Itoa(*field)
+		case *bool:
+			value.Tag, value.Value = "!!bool", strconv.FormatBool(*field)
+		case **Secret:
+			value.Tag, value.Value = "!!str", (*field).Value()
+			if redact && value.Value != "" {
+				value.Value = Redacted
+			}
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.ToLower(s.Key)}
+		doc.Content = append(doc.Content, key, value)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSecretNotFound is returned by a SecretProvider that has no such secret
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider looks up secrets by their setting key, e.g. JWT_SECRET
+type SecretProvider interface {
+	Secret(key string) (string, error)
+}
+
+// NewSecretProvider returns the provider chosen by SECRETS_PROVIDER, or nil
+// if secrets only come from values and *_FILE variables
+func NewSecretProvider(c *Config) (SecretProvider, error) {
+	switch c.SecretsProvider {
+	case "file":
+		return &FileProvider{Dir: c.SecretsDir}, nil
+	case "vault":
+		return NewVaultProvider(c)
+	}
+	return nil, nil
+}
+
+// NewVaultProvider returns the vault at SECRETS_VAULT, opened with
+// SECRETS_VAULT_KEY
+func NewVaultProvider(c *Config) (*VaultProvider, error) {
+	key, err := base64.StdEncoding.DecodeString(c.SecretsVaultKey.Value())
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("SECRETS_VAULT_KEY must be 32 bytes encoded in base64")
+	}
+	return &VaultProvider{Path: c.SecretsVault, Key: key}, nil
+}
+
+// FileProvider reads each secret from a file named after its key in
+// lowercase, e.g. /run/secrets/jwt_secret, as Docker and Kubernetes mount
+// them
+type FileProvider struct {
+	Dir string
+}
+
+// Secret returns the contents of the secret's file without the trailing
+// newline
+func (p *FileProvider) Secret(key string) (string, error) {
+	value, err := readSecretFile(filepath.Join(p.Dir, strings.ToLower(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrSecretNotFound
+	}
+	return value, err
+}
+
+// readSecretFile returns the contents of a secret file without the trailing
+// newline
+func readSecretFile(path string) (string, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(body), "\r\n"), nil
+}
+
+// VaultProvider reads secrets from a local file encrypted with AES-256-GCM,
+// written by the vault command. The file is read on every lookup, so secrets
+// changed in the vault are picked up on the next refresh.
+type VaultProvider struct {
+	Path string
+	Key  []byte // 32 bytes
+}
+
+// vaultFile is the on-disk form of a vault
+type vaultFile struct {
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"` // the encrypted JSON object of secrets
+}
+
+// Secret returns a secret from the vault
+func (p *VaultProvider) Secret(key string) (string, error) {
+	secrets, err := p.Load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[key]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+// Load decrypts every secret in the vault. A missing vault is empty.
+func (p *VaultProvider) Load() (map[string]string, error) {
+	body, err := os.ReadFile(p.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file vaultFile
+	if err := json.Unmarshal(body, &file); err != nil {
+		return nil, fmt.Errorf("vault %s is corrupt: %v", p.Path, err)
+	}
+	gcm, err := p.cipher()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("vault %s cannot be decrypted with this key", p.Path)
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("vault %s is corrupt: %v", p.Path, err)
+	}
+	return secrets, nil
+}
+
+// Save encrypts the secrets with a new nonce and replaces the vault, which
+// only its owner can read
+func (p *VaultProvider) Save(secrets map[string]string) error {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	gcm, err := p.cipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	body, err := json.Marshal(vaultFile{Nonce: nonce, Data: gcm.Seal(nil, nonce, plain, nil)})
+	if err != nil {
+		return err
+	}
+
+	// Write a temporary file and rename it, so readers never see half a vault
+	tmp := p.Path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.Path)
+}
+
+// cipher returns the AES-GCM cipher for the vault key
+func (p *VaultProvider) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(p.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// reloadable lists the settings that take effect without a restart. The
+// others are read once at startup, by the server, the database and the jobs.
+var reloadable = map[string]bool{
+	"DEBUG":                true,
+	"LOG_LEVEL":            true,
+	"CORS_ORIGINS":         true,
+	"RATE_LIMIT":           true,
+	"RATE_LIMIT_BURST":     true,
+	"JWT_EXPIRY":           true,
+	"REFRESH_TOKEN_EXPIRY": true,
+	"DB_MAX_OPEN_CONNS":    true,
+	"DB_MAX_IDLE_CONNS":    true,
+}
+
+// current is the configuration snapshot in use
+var current atomic.Pointer[Config]
+
+// Current returns the configuration snapshot in use. A snapshot is never
+// changed, so read it once and use it for the whole request.
+func Current() *Config {
+	return current.Load()
+}
+
+// Set makes a configuration the current snapshot
+func Set(c *Config) {
+	current.Store(c)
+}
+
+// Reload loads the configuration again from the same arguments and replaces
+// the current snapshot with one holding the new values of the reloadable
+// settings. It returns the keys that changed, and those that changed but
+// need a restart and were ignored. If the new configuration is invalid the
+// current one is kept. Secrets are not reloaded; they are re-read by
+// RefreshSecrets.
+func Reload(args []string) (changed, ignored []string, err error) {
+	loaded, _, err := Load(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Copy the current snapshot and apply the reloadable changes to it
+	old := Current()
+	next := *old
+	oldSettings, loadedSettings, nextSettings := old.settings(), loaded.settings(), next.settings()
+	for i, s := range nextSettings {
+		if _, isSecret := s.Value.(**Secret); isSecret || equal(oldSettings[i], loadedSettings[i]) {
+			continue
+		}
+		if !reloadable[s.Key] {
+			ignored = append(ignored, s.Key)
+			continue
+		}
+		copyValue(s, loadedSettings[i])
+		changed = append(changed, s.Key)
+	}
+	if err := next.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("reloaded configuration is invalid: %v", err)
+	}
+
+	if len(changed) > 0 {
+		Set(&next)
+	}
+	return changed, ignored, nil
+}
+
+// equal reports whether two settings of the same key hold the same value
+func equal(a, b setting) bool {
+	switch value := a.Value.(type) {
+	case *string:
+		return *value == *b.Value.(*string)
+	case *int:
+		return *value == *b.Value.(*int)
+	case *bool:
+		return *value == *b.Value.(*bool)
+	}
+	return true
+}
+
+// copyValue copies the value of a setting into another of the same key
+func copyValue(dst, src setting) {
+	switch value := dst.Value.(type) {
+	case *string:
+		*value = *src.Value.(*string)
+	case *int:
+		*value = *src.Value.(*int)
+	case *bool:
+		*value = *src.Value.(*bool)
+	}
+}
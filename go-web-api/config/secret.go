@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Redacted replaces secret values in config dumps and log lines
+const Redacted = "[REDACTED]"
+
+// Secret is a configuration value that is never printed or marshalled. It
+// can be rotated while in use, so read it with Value every time it is needed.
+type Secret struct {
+	value  atomic.Value           // string
+	source func() (string, error) // re-reads a secret loaded from a file or provider
+}
+
+// NewSecret returns a Secret holding value
+func NewSecret(value string) *Secret {
+	s := &Secret{}
+	s.value.Store(value)
+	return s
+}
+
+// Value returns the current value of the secret
+func (s *Secret) Value() string {
+	if s == nil {
+		return ""
+	}
+	value, _ := s.value.Load().(string)
+	return value
+}
+
+// Set replaces the value of the secret, which is no longer re-read from its
+// file or provider
+func (s *Secret) Set(value string) {
+	s.value.Store(value)
+	s.source = nil
+}
+
+// String hides the secret from fmt and log
+func (s *Secret) String() string {
+	return Redacted
+}
+
+// GoString hides the secret from %#v
+func (s *Secret) GoString() string {
+	return Redacted
+}
+
+// MarshalText hides the secret from encoders such as encoding/json
+func (s *Secret) MarshalText() ([]byte, error) {
+	return []byte(Redacted), nil
+}
+
+// load sets the secret from a file or provider, which refresh reads again
+func (s *Secret) load(source func() (string, error)) error {
+	value, err := source()
+	if err != nil {
+		return err
+	}
+	s.Set(value)
+	s.source = source
+	return nil
+}
+
+// refresh re-reads the secret from its file or provider and reports whether
+// it changed. Secrets given directly as values are never refreshed.
+func (s *Secret) refresh() (bool, error) {
+	if s.source == nil {
+		return false, nil
+	}
+	value, err := s.source()
+	if err != nil {
+		return false, err
+	}
+	if value == "" {
+		return false, errors.New("is empty")
+	}
+	if value == s.Value() {
+		return false, nil
+	}
+	s.value.Store(value)
+	return true, nil
+}
+
+// redactor is an io.Writer that replaces the current value of secrets with
+// Redacted before writing
+type redactor struct {
+	mu      sync.Mutex
+	w       io.Writer
+	secrets []*Secret
+	public  []string // default values, which are not secret
+}
+
+// NewRedactor returns a writer that strips the configured secrets from every
+// line written through it, for use with log.SetOutput. It reads the secrets
+// on every write, so rotated values are redacted too.
+func NewRedactor(w io.Writer, c *Config) io.Writer {
+	r := &redactor{w: w}
+	for _, s := range c.settings() {
+		if secret, ok := s.Value.(**Secret); ok {
+			r.secrets = append(r.secrets, *secret)
+			r.public = append(r.public, s.Default.(string))
+		}
+	}
+	return r
+}
+
+func (r *redactor) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	line := p
+	for i, secret := range r.secrets {
+		value := secret.Value()
+		if value == "" || value == r.public[i] {
+			continue
+		}
+		line = bytes.ReplaceAll(line, []byte(value), []byte(Redacted))
+	}
+	if _, err := r.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
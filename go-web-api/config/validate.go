@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Validate checks that every setting is in range, reporting all the invalid
+// ones at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	port := func(key string, value int) {
+		check(value >= 1 && value <= 65535, "%s must be between 1 and 65535, got %d", key, value)
+	}
+	positive := func(key string, value int) {
+		check(value > 0, "%s must be positive, got %d", key, value)
+	}
+	notNegative := func(key string, value int) {
+		check(value >= 0, "%s must not be negative, got %d", key, value)
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		check(false, "%s must be one of %q, got %q", key, allowed, value)
+	}
+
+	// Server
+	oneOf("APP_ENV", c.Env, "development", "production")
+	oneOf("LOG_LEVEL", c.LogLevel, "debug", "info", "warn", "error")
+	oneOf("LOG_FORMAT", c.LogFormat, "text", "json")
+	port("PORT", c.Port)
+	positive("READ_TIMEOUT", c.ReadTimeout)
+	positive("WRITE_TIMEOUT", c.WriteTimeout)
+	positive("HEALTH_CHECK_TIMEOUT", c.HealthCheckTimeout)
+	check(len(c.AllowedOrigins()) > 0, "CORS_ORIGINS must list at least one origin, or *")
+	notNegative("RATE_LIMIT", c.RateLimit)
+	positive("RATE_LIMIT_BURST", c.RateLimitBurst)
+	if c.MetricsAddr != "" {
+		_, _, err := net.SplitHostPort(c.MetricsAddr)
+		check(err == nil, "METRICS_ADDR must be a host:port address such as :9090, got %q", c.MetricsAddr)
+	}
+
+	// Database
+	oneOf("DB_DRIVER", c.DBDriver, "postgres", "sqlite")
+	if c.DBDriver == "postgres" {
+		port("DB_PORT", c.DBPort)
+	}
+	positive("DB_MAX_OPEN_CONNS", c.DBMaxOpenConns)
+	notNegative("DB_MAX_IDLE_CONNS", c.DBMaxIdleConns)
+	check(c.DBMaxIdleConns <= c.DBMaxOpenConns, "DB_MAX_IDLE_CONNS must not be more than DB_MAX_OPEN_CONNS (%d), got %d", c.DBMaxOpenConns, c.DBMaxIdleConns)
+	positive("DB_CONNECT_ATTEMPTS", c.DBConnectAttempts)
+	notNegative("DB_CONNECT_BACKOFF", c.DBConnectBackoff)
+
+	// JWT
+	check(c.JWTSecret.Value() != "", "JWT_SECRET must not be empty")
+	check(c.Env != "production" || c.JWTSecret.Value() != DefaultJWTSecret, "JWT_SECRET must be changed from the default in production")
+	positive("JWT_EXPIRY", c.JWTExpiry)
+	positive("REFRESH_TOKEN_EXPIRY", c.RefreshTokenExpiry)
+
+	// Background jobs
+	notNegative("TRASH_RETENTION_DAYS", c.TrashRetentionDays)
+	notNegative("TRASH_PURGE_INTERVAL", c.TrashPurgeInterval)
+	positive("ITEM_MOVE_TTL", c.ItemMoveTTL)
+	notNegative("ITEM_EXPIRY_INTERVAL", c.ItemExpiryInterval)
+	notNegative("EVENT_LOG_SIZE", c.EventLogSize)
+	positive("EVENT_HEARTBEAT", c.EventHeartbeat)
+	notNegative("WEBHOOK_INTERVAL", c.WebhookInterval)
+	positive("WEBHOOK_TIMEOUT", c.WebhookTimeout)
+	positive("WEBHOOK_MAX_ATTEMPTS", c.WebhookMaxAttempts)
+	notNegative("WEBHOOK_RETRY_BACKOFF", c.WebhookRetryBackoff)
+	notNegative("OUTBOX_INTERVAL", c.OutboxInterval)
+	notNegative("OUTBOX_RETENTION", c.OutboxRetention)
+
+	// Storage
+	oneOf("STORAGE", c.Storage, "database", "memory")
+
+	// Secrets
+	oneOf("SECRETS_PROVIDER", c.SecretsProvider, "none", "file", "vault")
+	notNegative("SECRETS_REFRESH_INTERVAL", c.SecretsRefreshInterval)
+
+	return errors.Join(errs...)
+}
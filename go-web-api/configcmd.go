@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+)
+
+// configUsage describes the config command
+const configUsage = `Usage: go-web-api config print [--redacted]
+
+Prints the configuration, after the config file, environment variables,
+flags and secret provider are applied, as a YAML config file.
+
+Flags:
+  --redacted  Print the secrets as [REDACTED]
+`
+
+// runConfig runs the config command and returns the exit code
+func runConfig(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	redacted := fs.Bool("redacted", false, "")
+	args, err := parseArgs(fs, args)
+	if err != nil || len(args) != 1 || args[0] != "print" {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+
+	if err := cfg.WriteYAML(os.Stdout, *redacted); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+		return 1
+	}
+	return 0
+}
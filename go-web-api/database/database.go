@@ -1,12 +1,17 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/lib/pq"
 	"github.com/niphawanphoopha/go-web-api/config"
 )
 
@@ -15,28 +20,94 @@ var (
 	DB *gorm.DB
 )
 
-// Init initializes the database connection
+// maxConnectBackoff caps the wait between connection attempts at startup
+const maxConnectBackoff = 30 * time.Second
+
+// Init initializes the database connection, retrying with a doubling backoff
+// while the database is unreachable, e.g. while it is still starting up
 func Init(cfg *config.Config) error {
 	var err error
+	if cfg.DBDriver != "postgres" && cfg.DBDriver != "sqlite" {
+		return fmt.Errorf("unknown database driver %q, expected postgres or sqlite", cfg.DBDriver)
+	}
 	
 	// Connect to the database
-	DB, err = gorm.Open("postgres", cfg.GetDBConnString())
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %v", err)
+	backoff := time.Duration(cfg.DBConnectBackoff) * time.Second
+	for attempt := 1; ; attempt++ {
+		DB, err = open(cfg)
+		if err == nil {
+			break
+		}
+		if attempt >= cfg.DBConnectAttempts {
+			return fmt.Errorf("failed to connect to database after %d attempts: %v", attempt, err)
+		}
+		log.Printf("Database not ready (attempt %d of %d): %v; retrying in %s", attempt, cfg.DBConnectAttempts, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
 	}
 	
-	// Set connection pool settings
-	DB.DB().SetMaxIdleConns(10)
-	DB.DB().SetMaxOpenConns(100)
-	DB.DB().SetConnMaxLifetime(time.Hour)
+	// Set connection pool settings. An in-memory SQLite database lives only
+	// as long as its connection, so that one is never replaced.
+	if cfg.DBDriver != "sqlite" {
+		DB.DB().SetConnMaxLifetime(time.Hour)
+	}
+	Configure(cfg)
 	
-	// Enable logging if debug mode is on
-	DB.LogMode(cfg.Debug)
+	// Log queries at debug level, which can be turned on by a reload
+	DB.LogMode(true)
+	DB.SetLogger(queryLogger{})
 	
 	log.Println("Database connection established")
 	return nil
 }
 
+// Configure applies the pool sizes of a configuration to the open database,
+// at startup and when the configuration is reloaded
+func Configure(cfg *config.Config) {
+	// SQLite allows one writer at a time, so keep a single connection
+	if cfg.DBDriver == "sqlite" {
+		DB.DB().SetMaxIdleConns(1)
+		DB.DB().SetMaxOpenConns(1)
+		return
+	}
+	DB.DB().SetMaxIdleConns(cfg.DBMaxIdleConns)
+	DB.DB().SetMaxOpenConns(cfg.DBMaxOpenConns)
+}
+
+// open connects to the configured database and pings it
+func open(cfg *config.Config) (*gorm.DB, error) {
+	if cfg.DBDriver == "sqlite" {
+		return gorm.Open("sqlite3", cfg.DBPath)
+	}
+	
+	// Build the connection string for every new connection, so a rotated
+	// password is used once the old connections reach their lifetime
+	pool := sql.OpenDB(connector{cfg})
+	db, err := gorm.Open("postgres", pool)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// connector opens PostgreSQL connections with the current configuration
+type connector struct {
+	cfg *config.Config
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := pq.NewConnector(c.cfg.GetDBConnString())
+	if err != nil {
+		return nil, err
+	}
+	return conn.Connect(ctx)
+}
+
+func (c connector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
 // Close closes the database connection
 func Close() error {
 	if DB != nil {
@@ -44,8 +115,3 @@ func Close() error {
 	}
 	return nil
 }
-
-// AutoMigrate runs auto migration for given models
-func AutoMigrate(models ...interface{}) error {
-	return DB.AutoMigrate(models...).Error
-} 
\ No newline at end of file
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/logging"
+)
+
+// WithContext returns a handle on db that logs its queries with the logger
+// of a context, so a request's queries carry its request ID
+func WithContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	db = db.New()
+	db.SetLogger(queryLogger{logging.FromContext(ctx)})
+	return db
+}
+
+// queryLogger logs gorm's queries and errors at debug level, with the
+// default logger if it has none. Query parameters are left out, since they
+// hold passwords and personal data.
+type queryLogger struct {
+	logger *slog.Logger
+}
+
+func (l queryLogger) Print(values ...interface{}) {
+	logger := l.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if len(values) < 2 {
+		logger.Debug("database", "message", fmt.Sprint(values...))
+		return
+	}
+
+	// Queries are logged as "sql", source, duration, query, parameters and
+	// rows affected, everything else as "log", source and message
+	if values[0] == "sql" && len(values) == 6 {
+		duration, _ := values[2].(time.Duration)
+		logger.Debug("query",
+			"sql", values[3],
+			"rows", values[5],
+			"duration_ms", float64(duration.Microseconds())/1000,
+			"source", values[1],
+		)
+		return
+	}
+	logger.Debug("database", "message", fmt.Sprint(values[2:]...), "source", values[1])
+}
@@ -0,0 +1,353 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationLockID is the key of the advisory lock held while migrating, so
+// only one replica migrates at a time
+const migrationLockID = 4715282601
+
+// Dialects lists the database drivers, each with its own directory of
+// migrations under migrations/
+var Dialects = []string{"postgres", "sqlite"}
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// migrationFile matches migration file names such as 0002_add_tags.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// migrationName matches the names accepted by CreateMigration
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Migration is a numbered schema change and the SQL that undoes it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration along with when it was applied, if it was.
+// Unknown is set for versions applied by a newer build.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+	Unknown   bool
+}
+
+// Migrations returns the migrations embedded in the binary for the open
+// database, oldest first
+func Migrations() ([]Migration, error) {
+	dir := "migrations/" + dialect()
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := migrationFiles.ReadFile(dir + "/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp applies every pending migration, each in its own transaction, and
+// returns the ones applied
+func MigrateUp() ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	err = withMigrationLock(func(conn *sql.Conn) error {
+		versions, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := versions[m.Version]; ok {
+				continue
+			}
+			err := runMigration(conn, m.Up, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)", m.Version, m.Name, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %d_%s failed: %v", m.Version, m.Name, err)
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown rolls back the last steps applied migrations, newest first, and
+// returns the ones rolled back
+func MigrateDown(steps int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack []Migration
+	err = withMigrationLock(func(conn *sql.Conn) error {
+		versions, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			m := migrations[i]
+			if _, ok := versions[m.Version]; !ok {
+				continue
+			}
+			err := runMigration(conn, m.Down, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+			if err != nil {
+				return fmt.Errorf("rollback of migration %d_%s failed: %v", m.Version, m.Name, err)
+			}
+			rolledBack = append(rolledBack, m)
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// MigrationStatuses returns every known migration and every applied version,
+// oldest first
+func MigrationStatuses() ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	err = withConn(func(conn *sql.Conn) error {
+		versions, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			status := MigrationStatus{Migration: m}
+			if appliedAt, ok := versions[m.Version]; ok {
+				status.AppliedAt = &appliedAt
+				delete(versions, m.Version)
+			}
+			statuses = append(statuses, status)
+		}
+
+		// Versions left were applied by a newer build
+		for version, appliedAt := range versions {
+			appliedAt := appliedAt
+			statuses = append(statuses, MigrationStatus{Migration: Migration{Version: version}, AppliedAt: &appliedAt, Unknown: true})
+		}
+		return nil
+	})
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, err
+}
+
+// CheckSchema returns an error if any migration has not been applied, so the
+// server does not start against a schema older than the code. It only reads
+// schema_migrations, through the pool, so readiness probes can run it, and
+// gives up when the context is done.
+func CheckSchema(ctx context.Context) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	versions, err := readVersions(ctx, DB.DB())
+	if err != nil {
+		return fmt.Errorf("failed to read applied migrations, run \"migrate up\" if there are none: %v", err)
+	}
+
+	var pending []string
+	for _, m := range migrations {
+		if _, ok := versions[m.Version]; !ok {
+			pending = append(pending, fmt.Sprintf("%04d_%s", m.Version, m.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind, %d pending migrations (%s); run \"migrate up\"", len(pending), strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// CreateMigration writes empty up and down files for a new migration to the
+// directory of every dialect under dir, numbered after the last migration of
+// any dialect, and returns their paths
+func CreateMigration(dir, name string) ([]string, error) {
+	if !migrationName.MatchString(name) {
+		return nil, fmt.Errorf("migration name must only contain lowercase letters, digits and underscores")
+	}
+
+	// Number the migration the same for every dialect
+	last := 0
+	for _, d := range Dialects {
+		entries, err := os.ReadDir(filepath.Join(dir, d))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if match := migrationFile.FindStringSubmatch(entry.Name()); match != nil {
+				if version, _ := strconv.Atoi(match[1]); version > last {
+					last = version
+				}
+			}
+		}
+	}
+
+	var paths []string
+	for _, d := range Dialects {
+		prefix := filepath.Join(dir, d, fmt.Sprintf("%04d_%s", last+1, name))
+		up, down := prefix+".up.sql", prefix+".down.sql"
+		if err := os.WriteFile(up, []byte(fmt.Sprintf("-- %s\n", name)), 0644); err != nil {
+			return paths, err
+		}
+		if err := os.WriteFile(down, []byte(fmt.Sprintf("-- Undo %s\n", name)), 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, up, down)
+	}
+	return paths, nil
+}
+
+// withMigrationLock runs fn on a connection holding the migration lock,
+// waiting for another replica to finish migrating first. SQLite has no
+// advisory locks and is not shared between replicas, so it takes none.
+func withMigrationLock(fn func(conn *sql.Conn) error) error {
+	return withConn(func(conn *sql.Conn) error {
+		if dialect() == "sqlite" {
+			return fn(conn)
+		}
+		ctx := context.Background()
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return fmt.Errorf("failed to take migration lock: %v", err)
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+		return fn(conn)
+	})
+}
+
+// withConn runs fn on a single connection, which session-level advisory
+// locks need
+func withConn(fn func(conn *sql.Conn) error) error {
+	conn, err := DB.DB().Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return fn(conn)
+}
+
+// appliedVersions creates the schema_migrations table if needed and returns
+// the applied versions with the time they were applied
+func appliedVersions(conn *sql.Conn) (map[int]time.Time, error) {
+	ctx := context.Background()
+	timestamp := "timestamp with time zone"
+	if dialect() == "sqlite" {
+		timestamp = "datetime"
+	}
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at `+timestamp+` NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return readVersions(ctx, conn)
+}
+
+// querier is a connection or a pool that reads rows
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// readVersions returns the applied versions in schema_migrations with the
+// time they were applied
+func readVersions(ctx context.Context, q querier) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+	return versions, rows.Err()
+}
+
+// dialect returns the name of the migrations directory for the open database
+func dialect() string {
+	if DB.Dialect().GetName() == "sqlite3" {
+		return "sqlite"
+	}
+	return "postgres"
+}
+
+// runMigration runs the SQL of a migration and the statement that records it
+// in one transaction, so a failed migration leaves no trace
+func runMigration(conn *sql.Conn, script, record string, args ...interface{}) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,4 @@
+-- Undo outbox_claims
+
+ALTER TABLE outbox_events DROP COLUMN published_to;
+ALTER TABLE outbox_events DROP COLUMN claimed_until;
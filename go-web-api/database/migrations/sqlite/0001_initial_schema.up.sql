@@ -0,0 +1,167 @@
+-- The SQLite version of the initial schema, with the same tables, columns and
+-- indexes as the PostgreSQL one.
+
+CREATE TABLE IF NOT EXISTS users (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    username text NOT NULL UNIQUE,
+    email text NOT NULL UNIQUE,
+    password text NOT NULL,
+    first_name text,
+    last_name text,
+    role text DEFAULT 'user',
+    version integer NOT NULL DEFAULT 1,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    tokens_revoked_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS items (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    title text NOT NULL,
+    description text,
+    price real NOT NULL,
+    type text,
+    owner_id integer,
+    version integer NOT NULL DEFAULT 1,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    expires_at datetime,
+    moved_at datetime,
+    returns_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_items_expires_at ON items (expires_at);
+CREATE INDEX IF NOT EXISTS idx_items_returns_at ON items (returns_at);
+CREATE INDEX IF NOT EXISTS idx_items_type ON items (type);
+CREATE INDEX IF NOT EXISTS idx_items_owner_id ON items (owner_id);
+CREATE INDEX IF NOT EXISTS idx_items_deleted_at ON items (deleted_at);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL,
+    token_hash text NOT NULL UNIQUE,
+    family_id text NOT NULL,
+    expires_at datetime NOT NULL,
+    used_at datetime,
+    revoked_at datetime,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    jti text NOT NULL UNIQUE,
+    user_id integer,
+    expires_at datetime NOT NULL,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name text NOT NULL UNIQUE,
+    description text
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name text NOT NULL UNIQUE,
+    description text,
+    created_at datetime,
+    updated_at datetime
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id integer,
+    permission_id integer,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS todos (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name text NOT NULL,
+    type text NOT NULL,
+    state text NOT NULL DEFAULT 'main',
+    moved_at datetime,
+    moved_by_id integer,
+    created_by_id integer,
+    version integer NOT NULL DEFAULT 1,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_todos_type ON todos (type);
+CREATE INDEX IF NOT EXISTS idx_todos_state ON todos (state);
+CREATE INDEX IF NOT EXISTS idx_todos_created_by_id ON todos (created_by_id);
+CREATE INDEX IF NOT EXISTS idx_todos_deleted_at ON todos (deleted_at);
+
+CREATE TABLE IF NOT EXISTS todo_types (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name text NOT NULL UNIQUE,
+    position integer,
+    created_at datetime,
+    updated_at datetime
+);
+
+CREATE TABLE IF NOT EXISTS todo_transitions (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    todo_id integer NOT NULL,
+    action text NOT NULL,
+    from_state text NOT NULL,
+    to_state text NOT NULL,
+    type text,
+    user_id integer,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_todo_transitions_todo_id ON todo_transitions (todo_id);
+CREATE INDEX IF NOT EXISTS idx_todo_transitions_user_id ON todo_transitions (user_id);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text NOT NULL,
+    active boolean NOT NULL,
+    created_by_id integer,
+    created_at datetime,
+    updated_at datetime
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    webhook_id integer NOT NULL,
+    event_id text NOT NULL,
+    event_type text NOT NULL,
+    payload text NOT NULL,
+    status text NOT NULL,
+    attempts integer NOT NULL,
+    response_code integer,
+    response_body text,
+    error text,
+    next_attempt_at datetime,
+    last_attempt_at datetime,
+    created_at datetime,
+    updated_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_event_id ON webhook_deliveries (event_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries (status);
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_id text NOT NULL UNIQUE,
+    topic text NOT NULL,
+    type text NOT NULL,
+    owner_id integer,
+    data text NOT NULL,
+    attempts integer NOT NULL,
+    last_error text,
+    created_at datetime,
+    published_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_published_at ON outbox_events (published_at);
@@ -0,0 +1,5 @@
+-- Claim outbox events while they are relayed, and record the publishers that
+-- have taken each event so one failing publisher does not hold up the others
+
+ALTER TABLE outbox_events ADD COLUMN claimed_until datetime;
+ALTER TABLE outbox_events ADD COLUMN published_to text NOT NULL DEFAULT '';
@@ -0,0 +1,176 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind before it
+// is dropped
+const subscriberBuffer = 64
+
+// Event is a change to a resource. Topic names the resource kind, such as
+// "items", and Type the change, such as "item.updated". ID is assigned by the
+// broker, while EventID stays the same if the event is relayed again. Seq
+// numbers the events of one list, the shared todo board or the items of one
+// user, without gaps, so clients can tell that they missed a change to a list
+// they follow.
+type Event struct {
+	ID        uint64          `json:"id"`
+	EventID   string          `json:"event_id"`
+	Seq       uint64          `json:"seq"`
+	Topic     string          `json:"topic"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+
+	// OwnerID is the user the resource belongs to, 0 for shared resources
+	OwnerID uint `json:"-"`
+}
+
+// Broker fans events out to subscribers and keeps the most recent ones in a
+// bounded log so clients can resume after a reconnect
+type Broker struct {
+	mu          sync.RWMutex
+	nextID      uint64
+	log         []Event            // ring buffer of the most recent events
+	start       int                // index of the oldest event in log
+	size        int                // number of events in log
+	seqs        map[listKey]uint64 // last sequence number of each list
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// listKey identifies a list of resources: a topic and the owner of the
+// resources, 0 for shared ones
+type listKey struct {
+	topic   string
+	ownerID uint
+}
+
+// Subscription receives the events published after it was created. Its
+// channel is closed if the subscriber falls too far behind or unsubscribes.
+type Subscription struct {
+	C      <-chan Event
+	events chan Event
+}
+
+// NewBroker returns a broker that keeps the last logSize events
+func NewBroker(logSize int) *Broker {
+	if logSize < 1 {
+		logSize = 1
+	}
+	return &Broker{
+		nextID:      1,
+		log:         make([]Event, logSize),
+		seqs:        make(map[listKey]uint64),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the next ID and list sequence number to an event, adds it
+// to the log and sends it to every subscriber. Subscribers that are not
+// keeping up are dropped; they can resume from the log.
+func (b *Broker) Publish(event Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event.ID = b.nextID
+	b.nextID++
+	list := listKey{event.Topic, event.OwnerID}
+	b.seqs[list]++
+	event.Seq = b.seqs[list]
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	// Append to the ring buffer, overwriting the oldest event when full
+	end := (b.start + b.size) % len(b.log)
+	b.log[end] = event
+	if b.size < len(b.log) {
+		b.size++
+	} else {
+		b.start = (b.start + 1) % len(b.log)
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+	return event
+}
+
+// Subscribe returns a subscription to the events published from now on
+func (b *Broker) Subscribe() *Subscription {
+	events := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: events, events: events}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(events)
+		return sub
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops a subscription and closes its channel
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Close ends every subscription, such as when the server shuts down.
+// Subscriptions created afterwards are closed right away.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// LastID returns the ID of the most recent event, 0 if there is none
+func (b *Broker) LastID() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.nextID - 1
+}
+
+// Since returns the logged events published after the event with the given
+// ID. It returns false if events after that ID have already left the log, or
+// if the ID was never issued, in which case the client has to reload.
+func (b *Broker) Since(lastID uint64) ([]Event, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if lastID >= b.nextID {
+		return nil, false
+	}
+	oldest := b.nextID - uint64(b.size)
+	if lastID+1 < oldest {
+		return nil, false
+	}
+
+	skip := int(lastID + 1 - oldest)
+	events := make([]Event, 0, b.size-skip)
+	for i := skip; i < b.size; i++ {
+		events = append(events, b.log[(b.start+i)%len(b.log)])
+	}
+	return events, true
+}
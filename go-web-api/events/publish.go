@@ -0,0 +1,127 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// Topics of the events published by the API
+const (
+	TopicItems = "items"
+	TopicTodos = "todos"
+	TopicUsers = "users"
+)
+
+// Change types, appended to the resource name, e.g. "item.updated"
+const (
+	Created    = "created"
+	Updated    = "updated"
+	Deleted    = "deleted"
+	Restored   = "restored"
+	Registered = "registered"
+)
+
+// Types lists every event type the API publishes
+var Types = []string{
+	"item.created", "item.updated", "item.deleted", "item.restored",
+	"todo.created", "todo.updated", "todo.deleted",
+	"user.registered", "user.updated", "user.deleted", "user.restored",
+}
+
+// defaultLogSize is the number of events kept until Init is called
+const defaultLogSize = 1000
+
+// Default is the broker that streams relayed events to connected clients
+var Default = NewBroker(defaultLogSize)
+
+// Init replaces the default broker with one that keeps the last logSize events
+func Init(logSize int) {
+	Default = NewBroker(logSize)
+}
+
+// Record writes the event for a change to an item, todo or user to the
+// outbox. It must run in the transaction that makes the change, so the event
+// is stored if and only if the change is; the relay publishes it after the
+// commit.
+func Record(db *gorm.DB, change string, resource interface{}) error {
+	event, err := newOutboxEvent(change, resource)
+	if err != nil {
+		return err
+	}
+	return db.Create(&event).Error
+}
+
+// Publish sends the event for a change straight to the publishers and the
+// broker, skipping the outbox. It is for stores that keep no outbox, such as
+// the in-memory store, whose changes are lost on a crash anyway.
+func Publish(change string, resource interface{}) error {
+	row, err := newOutboxEvent(change, resource)
+	if err != nil {
+		return err
+	}
+	row.CreatedAt = time.Now()
+	event := eventFromOutbox(&row)
+
+	// Every publisher gets the event even if one fails, since it is not retried
+	var failed error
+	for _, p := range publishers {
+		if err := p.publish(event); err != nil && failed == nil {
+			failed = fmt.Errorf("%s publisher failed on event %s: %v", p.name, event.EventID, err)
+		}
+	}
+	Default.Publish(event)
+	return failed
+}
+
+// newOutboxEvent builds the outbox row for a change to an item, todo or user
+func newOutboxEvent(change string, resource interface{}) (models.OutboxEvent, error) {
+	var event models.OutboxEvent
+	switch r := resource.(type) {
+	case *models.Item:
+		event = models.OutboxEvent{Topic: TopicItems, Type: "item." + change, OwnerID: r.OwnerID}
+	case *models.Todo:
+		// Todos are shared, so their events have no owner
+		event = models.OutboxEvent{Topic: TopicTodos, Type: "todo." + change}
+	case *models.User:
+		// User events are not streamed to clients, only to other publishers
+		event = models.OutboxEvent{Topic: TopicUsers, Type: "user." + change, OwnerID: r.ID}
+	default:
+		return event, fmt.Errorf("no events for %T", resource)
+	}
+
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return event, err
+	}
+	event.Data = string(data)
+	if event.EventID, err = newEventID(); err != nil {
+		return event, err
+	}
+	return event, nil
+}
+
+// Match checks if an event type matches a filter: the type itself, a
+// resource wildcard such as "item.*", or "*" for every event
+func Match(filter, eventType string) bool {
+	if filter == "*" || filter == eventType {
+		return true
+	}
+	return strings.HasSuffix(filter, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(filter, "*"))
+}
+
+// newEventID generates a random event ID, the same for every delivery of the
+// event
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "evt_" + hex.EncodeToString(b), nil
+}
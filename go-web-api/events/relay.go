@@ -0,0 +1,217 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+const (
+	// relayBatch is the most outbox events relayed to a publisher per run
+	relayBatch = 100
+	// relayLease is how long an instance keeps an event it relays to itself,
+	// after which another instance can take it over
+	relayLease = time.Minute
+	// tailWindow is how long after an event was recorded it is looked for by
+	// the tail, so events whose transaction commits late are still streamed
+	tailWindow = time.Minute
+)
+
+// Publisher receives the events relayed from the outbox. An error leaves the
+// event in the outbox to be relayed again, so publishers must tolerate
+// repeats, using the event ID to drop them.
+type Publisher func(Event) error
+
+// namedPublisher is a publisher along with the name used in errors and in
+// the outbox
+type namedPublisher struct {
+	name    string
+	publish Publisher
+}
+
+var (
+	// publishers receive every relayed event
+	publishers []namedPublisher
+
+	// committed wakes the relay when events are committed to the outbox
+	committed = make(chan struct{}, 1)
+)
+
+// AddPublisher registers a publisher, such as the webhook dispatcher.
+// Publishers must be added before the relay starts, and their names must not
+// contain commas.
+func AddPublisher(name string, publisher Publisher) {
+	publishers = append(publishers, namedPublisher{name, publisher})
+}
+
+// Notify wakes the relay once a transaction that recorded events has been
+// committed, so they are published without waiting for the next poll
+func Notify() {
+	select {
+	case committed <- struct{}{}:
+	default:
+	}
+}
+
+// Committed returns the channel the relay waits on for Notify
+func Committed() <-chan struct{} {
+	return committed
+}
+
+// Relay publishes the outbox events to every publisher that has not taken
+// them yet, oldest first, and returns how many times an event was published.
+// Each publisher stops at the first event it fails on, which is retried on
+// the next run, so every publisher gets the events in order and a failing
+// publisher does not hold up the others. An event is marked published once
+// every publisher has taken it.
+func Relay(db *gorm.DB, now time.Time) (int, error) {
+	relayed := 0
+	var errs []error
+	for _, p := range publishers {
+		n, err := relayTo(db, p, now)
+		relayed += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return relayed, errors.Join(errs...)
+}
+
+// relayTo publishes the events a publisher has not taken yet, oldest first,
+// and returns how many it took
+func relayTo(db *gorm.DB, p namedPublisher, now time.Time) (int, error) {
+	var pending []models.OutboxEvent
+	err := db.Where("published_at IS NULL AND (',' || published_to || ',') NOT LIKE ?", "%,"+p.name+",%").
+		Order("id").Limit(relayBatch).Find(&pending).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range pending {
+		row := &pending[i]
+
+		// Claim the event so other instances skip it while it is published. A
+		// claim fails if another instance holds the event or has published it
+		// since it was read; later events wait behind it so order is kept.
+		claim := db.Model(&models.OutboxEvent{}).
+			Where("id = ? AND published_to = ? AND (claimed_until IS NULL OR claimed_until <= ?)", row.ID, row.PublishedTo, now).
+			UpdateColumn("claimed_until", now.Add(relayLease))
+		if claim.Error != nil {
+			return i, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return i, nil
+		}
+
+		if err := p.publish(eventFromOutbox(row)); err != nil {
+			db.Model(row).Updates(map[string]interface{}{
+				"attempts":      row.Attempts + 1,
+				"last_error":    p.name + ": " + err.Error(),
+				"claimed_until": nil,
+			})
+			return i, fmt.Errorf("%s publisher failed on event %s: %v", p.name, row.EventID, err)
+		}
+
+		// Record the publisher, and mark the event published once every
+		// publisher has taken it
+		publishedTo := p.name
+		if row.PublishedTo != "" {
+			publishedTo = row.PublishedTo + "," + p.name
+		}
+		changes := map[string]interface{}{"published_to": publishedTo, "claimed_until": nil}
+		if publishedToAll(publishedTo) {
+			changes["published_at"] = time.Now()
+		}
+		if err := db.Model(row).Updates(changes).Error; err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+// publishedToAll reports whether a list of publishers names every registered
+// publisher
+func publishedToAll(publishedTo string) bool {
+	taken := strings.Split(publishedTo, ",")
+	for _, p := range publishers {
+		if !slices.Contains(taken, p.name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tail streams the events committed to the outbox to the local broker, so
+// the clients of every instance get every event, whichever instance recorded
+// it. Only events recorded after the tail was created are streamed.
+type Tail struct {
+	started time.Time
+	floor   uint               // events up to this ID are too old to be streamed
+	seen    map[uint]time.Time // streamed events above floor, by creation time
+}
+
+// NewTail returns a tail streaming the events recorded from now on
+func NewTail(now time.Time) *Tail {
+	return &Tail{started: now, seen: make(map[uint]time.Time)}
+}
+
+// Stream sends the events committed since the last run to the default
+// broker, in the order they were recorded, and returns how many were sent
+func (t *Tail) Stream(db *gorm.DB, now time.Time) (int, error) {
+	since := now.Add(-tailWindow)
+	if since.Before(t.started) {
+		since = t.started
+	}
+	var recent []models.OutboxEvent
+	if err := db.Where("id > ? AND created_at >= ?", t.floor, since).Order("id").Find(&recent).Error; err != nil {
+		return 0, err
+	}
+
+	streamed := 0
+	for i := range recent {
+		row := &recent[i]
+		if _, ok := t.seen[row.ID]; ok {
+			continue
+		}
+		t.seen[row.ID] = row.CreatedAt
+		Default.Publish(eventFromOutbox(row))
+		streamed++
+	}
+
+	// Forget the events that are no longer looked for. Events are numbered
+	// as they are recorded, so the older ones are below them.
+	for id, createdAt := range t.seen {
+		if createdAt.Before(since) {
+			delete(t.seen, id)
+			if id > t.floor {
+				t.floor = id
+			}
+		}
+	}
+	return streamed, nil
+}
+
+// eventFromOutbox returns the event stored in an outbox row
+func eventFromOutbox(row *models.OutboxEvent) Event {
+	return Event{
+		EventID:   row.EventID,
+		Topic:     row.Topic,
+		Type:      row.Type,
+		Data:      json.RawMessage(row.Data),
+		CreatedAt: row.CreatedAt,
+		OwnerID:   row.OwnerID,
+	}
+}
+
+// LogEvent is a publisher that writes every event to the log
+func LogEvent(event Event) error {
+	log.Printf("Event %s %s", event.Type, event.EventID)
+	return nil
+}
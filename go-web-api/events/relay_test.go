@@ -0,0 +1,134 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// testOutbox returns a migrated in-memory SQLite database holding the outbox
+// events of the given items, and replaces the publishers and the default
+// broker for the test
+func testOutbox(t *testing.T, titles ...string) *gorm.DB {
+	t.Helper()
+	cfg := &config.Config{DBDriver: "sqlite", DBPath: ":memory:", DBConnectAttempts: 1}
+	if err := database.Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if _, err := database.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, broker := publishers, Default
+	publishers, Default = nil, NewBroker(10)
+	t.Cleanup(func() { publishers, Default = saved, broker })
+
+	for _, title := range titles {
+		if err := Record(database.DB, Created, &models.Item{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return database.DB
+}
+
+// recorder is a publisher that keeps the titles of the items it was sent, and
+// fails while failing is set
+type recorder struct {
+	titles  []string
+	failing bool
+}
+
+func (r *recorder) publish(event Event) error {
+	if r.failing {
+		return errors.New("unavailable")
+	}
+	var item struct{ Title string }
+	if err := json.Unmarshal(event.Data, &item); err != nil {
+		return err
+	}
+	r.titles = append(r.titles, item.Title)
+	return nil
+}
+
+func TestRelayFailingPublisherDoesNotHoldUpOthers(t *testing.T) {
+	db := testOutbox(t, "first", "second")
+	webhooks, log := &recorder{failing: true}, &recorder{}
+	AddPublisher("webhooks", webhooks.publish)
+	AddPublisher("log", log.publish)
+
+	// The log gets every event while the webhooks fail
+	if _, err := Relay(db, time.Now()); err == nil {
+		t.Fatal("Relay() succeeded with a failing publisher")
+	}
+	if len(log.titles) != 2 || len(webhooks.titles) != 0 {
+		t.Fatalf("log got %v and webhooks %v, want every event for the log only", log.titles, webhooks.titles)
+	}
+
+	// Once the webhooks recover they get the events in order, and the log
+	// does not get them again
+	webhooks.failing = false
+	if _, err := Relay(db, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks.titles) != 2 || webhooks.titles[0] != "first" || len(log.titles) != 2 {
+		t.Fatalf("webhooks got %v and log %v, want both events once each", webhooks.titles, log.titles)
+	}
+	var pending int
+	if err := db.Model(&models.OutboxEvent{}).Where("published_at IS NULL").Count(&pending).Error; err != nil {
+		t.Fatal(err)
+	}
+	if pending != 0 {
+		t.Errorf("%d events left unpublished, want none", pending)
+	}
+}
+
+func TestRelaySkipsClaimedEvents(t *testing.T) {
+	db := testOutbox(t, "first", "second")
+	log := &recorder{}
+	AddPublisher("log", log.publish)
+
+	// Another instance is relaying the first event, and the second waits
+	// behind it
+	now := time.Now()
+	if err := db.Model(&models.OutboxEvent{}).Where("id = ?", 1).UpdateColumn("claimed_until", now.Add(time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if relayed, err := Relay(db, now); err != nil || relayed != 0 {
+		t.Fatalf("Relay() = %d, %v, want nothing relayed", relayed, err)
+	}
+
+	// Once its lease runs out the event is taken over
+	if relayed, err := Relay(db, now.Add(2*time.Minute)); err != nil || relayed != 2 {
+		t.Fatalf("Relay() = %d, %v, want both events relayed", relayed, err)
+	}
+}
+
+func TestTailStreamsEveryCommittedEventOnce(t *testing.T) {
+	db := testOutbox(t, "before")
+	tail := NewTail(time.Now())
+	if err := Record(db, Created, &models.Item{Title: "after"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the event recorded after the tail started is streamed, once
+	for run := 0; run < 2; run++ {
+		streamed, err := tail.Stream(db, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := 1 - run; streamed != want {
+			t.Fatalf("run %d streamed %d events, want %d", run, streamed, want)
+		}
+	}
+	events, _ := Default.Since(0)
+	if len(events) != 1 || events[0].Type != "item.created" {
+		t.Errorf("broker has %v, want the one item event", events)
+	}
+}
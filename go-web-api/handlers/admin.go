@@ -2,41 +2,77 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strconv"
 
-	"github.com/gorilla/mux"
-	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/middleware"
 	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
 )
 
-// GetAllUsers returns all users (admin only)
+// GetAllUsers returns a page of users (admin only). With limit and offset
+// the response is a plain array; with pagination=cursor it is a list
+// envelope carrying signed next and previous cursors.
 func GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	var users []models.User
-	
 	// Get query parameters
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
-	
-	// Set default values
-	if limit <= 0 {
-		limit = 10
+	limit, offset, err := parsePagination(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if offset < 0 {
-		offset = 0
+	sort, err := parseSort(r.URL.Query().Get("sort"), models.UserSortFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	
-	// Query the database
-	query := database.DB.Model(&models.User{})
+	// Count all users for the pagination links
+	store := r.Context().Value("store").(*storage.Store)
+	filter := models.UserFilter{Sort: sort, Limit: limit, Offset: offset}
+	total, err := store.Users.Count(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch users")
+		return
+	}
 	
-	// Apply pagination
-	query = query.Limit(limit).Offset(offset)
+	if usesCursor(r.URL.Query()) {
+		// Apply keyset pagination
+		cfg := r.Context().Value("config").(*config.Config)
+		fetch := func(order []models.SortField, after []interface{}, limit int) ([]models.User, error) {
+			return store.Users.List(r.Context(), models.UserFilter{Sort: order, After: after, Limit: limit})
+		}
+		page, cursors, err := paginateCursor(sort, r.URL.Query().Get("cursor"), limit, cfg.CursorSecret.Value(), fetch, models.UserSortValue)
+		if err == errInvalidCursor {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err, "Failed to fetch users")
+			return
+		}
+		setLinkHeader(w, r, cursorLinks(cursors))
+		
+		// Return the users
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ListResponse{
+			Items:      page,
+			Total:      total,
+			Limit:      limit,
+			NextCursor: cursors.NextCursor,
+			PrevCursor: cursors.PrevCursor,
+		})
+		return
+	}
 	
-	// Execute the query
-	if err := query.Find(&users).Error; err != nil {
-		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+	// Apply sorting and pagination
+	users, err := store.Users.List(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch users")
 		return
 	}
+	setLinkHeader(w, r, offsetLinks(limit, offset, total))
 	
 	// Return the users
 	w.Header().Set("Content-Type", "application/json")
@@ -46,18 +82,19 @@ func GetAllUsers(w http.ResponseWriter, r *http.Request) {
 
 // GetUserByID returns a user by ID (admin only)
 func GetUserByID(w http.ResponseWriter, r *http.Request) {
-	// Get the ID from the URL
-	vars := mux.Vars(r)
-	id := vars["id"]
+	// Find the user in the store
+	user, ok := findUser(w, r)
+	if !ok {
+		return
+	}
 	
-	// Find the user in the database
-	var user models.User
-	if database.DB.First(&user, id).RecordNotFound() {
-		http.Error(w, "User not found", http.StatusNotFound)
+	// Skip the body if the client already has this version
+	if notModified(w, r, etag(user.Version)) {
 		return
 	}
 	
 	// Return the user
+	w.Header().Set("ETag", etag(user.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(user)
@@ -65,14 +102,14 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 
 // UpdateUser updates a user (admin only)
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
-	// Get the ID from the URL
-	vars := mux.Vars(r)
-	id := vars["id"]
+	// Find the user in the store
+	user, ok := findUser(w, r)
+	if !ok {
+		return
+	}
 	
-	// Find the user in the database
-	var user models.User
-	if database.DB.First(&user, id).RecordNotFound() {
-		http.Error(w, "User not found", http.StatusNotFound)
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(user.Version)) {
 		return
 	}
 	
@@ -83,40 +120,177 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Update the user
-	user.FirstName = updatedUser.FirstName
-	user.LastName = updatedUser.LastName
-	user.Email = updatedUser.Email
-	user.Role = updatedUser.Role
+	// Validate input
+	if updatedUser.Email == "" || updatedUser.Role == "" {
+		http.Error(w, "Email and role are required", http.StatusBadRequest)
+		return
+	}
+	
+	// Save the updated user, checking the role and email like a patch
+	changes := map[string]interface{}{
+		"first_name": updatedUser.FirstName,
+		"last_name":  updatedUser.LastName,
+		"email":      updatedUser.Email,
+		"role":       updatedUser.Role,
+	}
+	if !saveUserChanges(w, r, user, changes) {
+		return
+	}
+	
+	// Return the updated user
+	w.Header().Set("ETag", etag(user.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// PatchUser partially updates a user with a JSON Merge Patch or a JSON Patch
+// (admin only). Only the first name, last name, email and role can be patched.
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	// Find the user in the store
+	user, ok := findUser(w, r)
+	if !ok {
+		return
+	}
+	
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(user.Version)) {
+		return
+	}
+	
+	// Apply the patch to the patchable fields
+	doc, err := applyPatch(r, map[string]interface{}{
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"email":      user.Email,
+		"role":       user.Role,
+	})
+	if err != nil {
+		writePatchError(w, err)
+		return
+	}
+	
+	// Validate the patched fields
+	changes, err := userPatchChanges(doc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	
-	// Save the updated user to the database
-	if err := database.DB.Save(&user).Error; err != nil {
-		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+	// A patch changing nothing keeps the version, so other clients' entity
+	// tags stay valid
+	if !userUnchanged(user, changes) && !saveUserChanges(w, r, user, changes) {
 		return
 	}
 	
 	// Return the updated user
+	w.Header().Set("ETag", etag(user.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(user)
 }
 
+// saveUserChanges checks the role and email of a user's changes and saves
+// them unless the user changed since it was read, revoking the user's tokens
+// if the role changed. It writes an error response and returns false if the
+// changes were not saved.
+func saveUserChanges(w http.ResponseWriter, r *http.Request, user *models.User, changes map[string]interface{}) bool {
+	if changes["role"] != user.Role && !roleExists(w, r, changes["role"].(string)) {
+		return false
+	}
+	store := r.Context().Value("store").(*storage.Store)
+	if changes["email"] != user.Email {
+		_, err := store.Users.FindByEmail(r.Context(), changes["email"].(string))
+		if err == nil {
+			http.Error(w, "Email already exists", http.StatusConflict)
+			return false
+		}
+		if err != storage.ErrNotFound {
+			serverError(w, r, err, "Failed to update user")
+			return false
+		}
+	}
+	
+	// Save the user to the store unless it changed since it was read
+	roleChanged := changes["role"] != user.Role
+	saved, err := store.Users.Update(r.Context(), user, changes)
+	if err != nil {
+		serverError(w, r, err, "Failed to update user")
+		return false
+	}
+	if !saved {
+		writeConflict(w, r)
+		return false
+	}
+	
+	// Tokens carry the role, so revoke the ones issued with the old role
+	if roleChanged {
+		if err := middleware.Revocations.RevokeUser(user.ID); err != nil {
+			serverError(w, r, err, "Failed to revoke user tokens")
+			return false
+		}
+	}
+	return true
+}
+
+// userUnchanged reports whether saving the changes of a patch would leave the
+// user as it is
+func userUnchanged(user *models.User, changes map[string]interface{}) bool {
+	return changes["first_name"] == user.FirstName &&
+		changes["last_name"] == user.LastName &&
+		changes["email"] == user.Email &&
+		changes["role"] == user.Role
+}
+
+// userPatchChanges validates a patched user document and returns the column
+// values to save
+func userPatchChanges(doc map[string]interface{}) (map[string]interface{}, error) {
+	changes := make(map[string]interface{}, len(doc))
+	for _, key := range []string{"first_name", "last_name", "email", "role"} {
+		value, err := patchString(doc, key)
+		if err != nil {
+			return nil, err
+		}
+		changes[key] = value
+	}
+	
+	if changes["email"] == "" {
+		return nil, errors.New("email is required")
+	}
+	if changes["role"] == "" {
+		return nil, errors.New("role is required")
+	}
+	return changes, nil
+}
+
 // DeleteUser deletes a user (admin only)
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	// Get the ID from the URL
-	vars := mux.Vars(r)
-	id := vars["id"]
+	// Find the user in the store
+	user, ok := findUser(w, r)
+	if !ok {
+		return
+	}
 	
-	// Find the user in the database
-	var user models.User
-	if database.DB.First(&user, id).RecordNotFound() {
-		http.Error(w, "User not found", http.StatusNotFound)
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(user.Version)) {
+		return
+	}
+	
+	// Move the user to the trash unless it changed since it was read
+	store := r.Context().Value("store").(*storage.Store)
+	deleted, err := store.Users.Delete(r.Context(), user)
+	if err != nil {
+		serverError(w, r, err, "Failed to delete user")
+		return
+	}
+	if !deleted {
+		writeConflict(w, r)
 		return
 	}
 	
-	// Delete the user from the database
-	if err := database.DB.Delete(&user).Error; err != nil {
-		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+	// Revoke all of the deleted user's tokens
+	if err := middleware.Revocations.RevokeUser(user.ID); err != nil {
+		serverError(w, r, err, "Failed to revoke user tokens")
 		return
 	}
 	
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// createUsers saves users with the given names to a store, numbered from 1
+func createUsers(t *testing.T, store *storage.Store, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		user := models.User{Username: name, Email: name + "@example.com", Password: "secret"}
+		if err := store.Users.Create(context.Background(), &user); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetCurrentUser(t *testing.T) {
+	store := storage.NewMemoryStore()
+	createUsers(t, store, "alice", "bob")
+
+	w := serve(GetCurrentUser, store, 2, "GET", "/api/users/me", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	var user models.User
+	if err := json.NewDecoder(w.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "bob" || user.Password != "" {
+		t.Errorf("current user = %+v, want bob without the password", user)
+	}
+}
+
+func TestGetUserByIDHidesTrashedUsers(t *testing.T) {
+	store := storage.NewMemoryStore()
+	createUsers(t, store, "alice")
+	id := map[string]string{"id": "1"}
+
+	w := serve(GetUserByID, store, 1, "GET", "/api/admin/users/1", "", id)
+	expectStatus(t, w, http.StatusOK)
+
+	user, err := store.Users.Find(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := store.Users.Delete(context.Background(), user); err != nil || !deleted {
+		t.Fatalf("Delete() = %v, %v, want it deleted", deleted, err)
+	}
+	w = serve(GetUserByID, store, 1, "GET", "/api/admin/users/1", "", id)
+	expectStatus(t, w, http.StatusNotFound)
+}
+
+func TestPatchUserEmailTakenByTrashedUser(t *testing.T) {
+	store := storage.NewMemoryStore()
+	createUsers(t, store, "alice", "bob")
+	user, err := store.Users.Find(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := store.Users.Delete(context.Background(), user); err != nil || !deleted {
+		t.Fatalf("Delete() = %v, %v, want it deleted", deleted, err)
+	}
+
+	w := serve(PatchUser, store, 1, "PATCH", "/api/admin/users/1", `{"email":"bob@example.com"}`,
+		map[string]string{"id": "1"}, "Content-Type", "application/merge-patch+json")
+	expectStatus(t, w, http.StatusConflict)
+
+	w = serve(PatchUser, store, 1, "PATCH", "/api/admin/users/1", `{"email":"alice@example.org"}`,
+		map[string]string{"id": "1"}, "Content-Type", "application/merge-patch+json")
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestPatchUserWithoutChangesKeepsVersion(t *testing.T) {
+	store := storage.NewMemoryStore()
+	createUsers(t, store, "alice")
+
+	w := serve(PatchUser, store, 1, "PATCH", "/api/admin/users/1", `{"email":"alice@example.com"}`,
+		map[string]string{"id": "1"}, "Content-Type", "application/merge-patch+json")
+	expectStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("ETag"); got != `"1"` {
+		t.Errorf("ETag after an empty patch = %s, want \"1\"", got)
+	}
+}
+
+func TestUpdateUserValidatesEmail(t *testing.T) {
+	store := storage.NewMemoryStore()
+	createUsers(t, store, "alice", "bob")
+	id := map[string]string{"id": "1"}
+
+	// The email is required and must not belong to another user
+	w := serve(UpdateUser, store, 1, "PUT", "/api/admin/users/1", `{"email":"","role":"user"}`, id)
+	expectStatus(t, w, http.StatusBadRequest)
+	w = serve(UpdateUser, store, 1, "PUT", "/api/admin/users/1", `{"email":"bob@example.com","role":"user"}`, id)
+	expectStatus(t, w, http.StatusConflict)
+
+	user, err := store.Users.Find(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" || user.Version != 1 {
+		t.Errorf("user after refused updates = %+v, want it unchanged", user)
+	}
+
+	w = serve(UpdateUser, store, 1, "PUT", "/api/admin/users/1", `{"first_name":"Alice","email":"alice@example.com","role":"user"}`, id)
+	expectStatus(t, w, http.StatusOK)
+}
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
-
+	"time"
+	
 	"github.com/niphawanphoopha/go-web-api/config"
-	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/metrics"
 	"github.com/niphawanphoopha/go-web-api/middleware"
 	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
 )
 
 // RegisterRequest represents the request body for user registration
@@ -25,10 +29,18 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// RefreshRequest represents the request body for refreshing tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 // AuthResponse represents the response for authentication endpoints
 type AuthResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	Token            string      `json:"token"`
+	ExpiresAt        time.Time   `json:"expires_at"`
+	RefreshToken     string      `json:"refresh_token"`
+	RefreshExpiresAt time.Time   `json:"refresh_expires_at"`
+	User             models.User `json:"user"`
 }
 
 // Register handles user registration
@@ -48,42 +60,41 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Check if username or email already exists
-	var existingUser models.User
-	if !database.DB.Where("username = ? OR email = ?", req.Username, req.Email).First(&existingUser).RecordNotFound() {
+	store := r.Context().Value("store").(*storage.Store)
+	_, err := store.Users.FindByUsernameOrEmail(r.Context(), req.Username, req.Email)
+	if err == nil {
 		http.Error(w, "Username or email already exists", http.StatusConflict)
 		return
 	}
+	if err != storage.ErrNotFound {
+		serverError(w, r, err, "Failed to create user")
+		return
+	}
 	
 	// Create new user
 	user := models.User{
 		Username:  req.Username,
 		Email:     req.Email,
-		Password:  req.Password, // Will be hashed by the BeforeCreate hook
+		Password:  req.Password, // Will be hashed by the store
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
 		Role:      "user", // Default role
 	}
 	
-	// Save user to database
-	if err := database.DB.Create(&user).Error; err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+	// Save user to the store
+	if err := store.Users.Create(r.Context(), &user); err != nil {
+		serverError(w, r, err, "Failed to create user")
 		return
 	}
 	
-	// Generate JWT token
+	// Generate access and refresh tokens
 	cfg := r.Context().Value("config").(*config.Config)
-	token, err := middleware.GenerateToken(user.ID, user.Username, user.Role, cfg)
+	response, err := issueTokens(r.Context(), store.Tokens, user, "", cfg)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		serverError(w, r, err, "Failed to generate token")
 		return
 	}
 	
-	// Return response
-	response := AuthResponse{
-		Token: token,
-		User:  user,
-	}
-	
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(response)
@@ -106,30 +117,108 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Find user by username
-	var user models.User
-	if database.DB.Where("username = ?", req.Username).First(&user).RecordNotFound() {
+	store := r.Context().Value("store").(*storage.Store)
+	user, err := store.Users.FindByUsername(r.Context(), req.Username)
+	if err == storage.ErrNotFound {
+		metrics.LoginsTotal.WithLabelValues("failure").Inc()
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
+	if err != nil {
+		serverError(w, r, err, "Failed to log in")
+		return
+	}
 	
 	// Check password
 	if !user.CheckPassword(req.Password) {
+		metrics.LoginsTotal.WithLabelValues("failure").Inc()
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
 	
-	// Generate JWT token
+	// Generate access and refresh tokens
 	cfg := r.Context().Value("config").(*config.Config)
-	token, err := middleware.GenerateToken(user.ID, user.Username, user.Role, cfg)
+	response, err := issueTokens(r.Context(), store.Tokens, *user, "", cfg)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		serverError(w, r, err, "Failed to generate token")
+		return
+	}
+	metrics.LoginsTotal.WithLabelValues("success").Inc()
+	
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token pair.
+// Refresh tokens are single-use: presenting one that was already rotated
+// revokes every token in its family.
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	
+	// Parse request body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 	
-	// Return response
-	response := AuthResponse{
-		Token: token,
-		User:  user,
+	// Validate request
+	if req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+	
+	// Find the refresh token by its hash
+	store := r.Context().Value("store").(*storage.Store)
+	stored, err := store.Tokens.FindRefreshToken(r.Context(), models.HashToken(req.RefreshToken))
+	if err == storage.ErrNotFound {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err, "Failed to refresh token")
+		return
+	}
+	
+	if stored.RevokedAt != nil || stored.IsExpired() {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	
+	// Mark the token as used, which only one of two concurrent requests with
+	// the same token can do
+	used, err := store.Tokens.UseRefreshToken(r.Context(), stored.ID, time.Now())
+	if err != nil {
+		serverError(w, r, err, "Failed to refresh token")
+		return
+	}
+	if !used {
+		// The token was already rotated, so it has been leaked or replayed
+		if err := store.Tokens.RevokeFamily(r.Context(), stored.FamilyID, time.Now()); err != nil {
+			serverError(w, r, err, "Failed to refresh token")
+			return
+		}
+		http.Error(w, "Refresh token reuse detected", http.StatusUnauthorized)
+		return
+	}
+	
+	// Find the user the token belongs to
+	user, err := store.Users.Find(r.Context(), stored.UserID)
+	if err == storage.ErrNotFound {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err, "Failed to refresh token")
+		return
+	}
+	
+	// Generate a new token pair in the same family
+	cfg := r.Context().Value("config").(*config.Config)
+	response, err := issueTokens(r.Context(), store.Tokens, *user, stored.FamilyID, cfg)
+	if err != nil {
+		serverError(w, r, err, "Failed to generate token")
+		return
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
@@ -137,6 +226,106 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// LogoutRequest represents the optional request body for logging out
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Logout revokes the access token used for the request and, if one is
+// supplied, the refresh token family it belongs to
+func Logout(w http.ResponseWriter, r *http.Request) {
+	// Get the claims from the context
+	claims, ok := r.Context().Value("user").(*middleware.Claims)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	
+	// Parse the optional request body, which is empty when no refresh token
+	// is supplied
+	var req LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	
+	// Revoke the access token
+	if err := middleware.Revocations.Revoke(claims); err != nil {
+		serverError(w, r, err, "Failed to log out")
+		return
+	}
+	
+	// Revoke the refresh token family if the token belongs to the caller
+	if req.RefreshToken != "" {
+		store := r.Context().Value("store").(*storage.Store)
+		stored, err := store.Tokens.FindRefreshToken(r.Context(), models.HashToken(req.RefreshToken))
+		if err != nil && err != storage.ErrNotFound {
+			serverError(w, r, err, "Failed to log out")
+			return
+		}
+		if err == nil && stored.UserID == claims.UserID {
+			if err := store.Tokens.RevokeFamily(r.Context(), stored.FamilyID, time.Now()); err != nil {
+				serverError(w, r, err, "Failed to log out")
+				return
+			}
+		}
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
+}
+
+// LogoutAll revokes every access and refresh token issued to the current user
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	// Get the claims from the context
+	claims, ok := r.Context().Value("user").(*middleware.Claims)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	
+	if err := middleware.Revocations.RevokeUser(claims.UserID); err != nil {
+		serverError(w, r, err, "Failed to log out")
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out of all sessions"})
+}
+
+// issueTokens generates an access token and persists a new refresh token for
+// the user. An empty familyID starts a new token family.
+func issueTokens(ctx context.Context, tokens storage.TokenRepository, user models.User, familyID string, cfg *config.Config) (*AuthResponse, error) {
+	token, expiresAt, err := middleware.GenerateToken(user.ID, user.Username, user.Role, cfg)
+	if err != nil {
+		return nil, err
+	}
+	
+	if familyID == "" {
+		if familyID, err = models.NewTokenFamily(); err != nil {
+			return nil, err
+		}
+	}
+	
+	refreshToken, rawRefreshToken, err := models.NewRefreshToken(user.ID, familyID, time.Duration(cfg.RefreshTokenExpiry)*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if err := tokens.CreateRefreshToken(ctx, refreshToken); err != nil {
+		return nil, err
+	}
+	
+	return &AuthResponse{
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     rawRefreshToken,
+		RefreshExpiresAt: refreshToken.ExpiresAt,
+		User:             user,
+	}, nil
+}
+
 // GetCurrentUser returns the current user's information
 func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	// Get the claims from the context
@@ -147,14 +336,19 @@ func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Find user by ID
-	var user models.User
-	if database.DB.First(&user, claims.UserID).RecordNotFound() {
+	store := r.Context().Value("store").(*storage.Store)
+	user, err := store.Users.Find(r.Context(), claims.UserID)
+	if err == storage.ErrNotFound {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch user")
+		return
+	}
 	
 	// Return user information
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(user)
-} 
\ No newline at end of file
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// errInvalidCursor is returned for cursors that were tampered with or that
+// do not belong to the requested sort order
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursor marks a position in a sorted list. It holds the sort key values of
+// the row next to the requested page, so pages stay stable when rows are
+// inserted or deleted concurrently.
+type cursor struct {
+	Sort   string        `json:"s"`           // canonical sort order the cursor was issued for
+	Values []interface{} `json:"v"`           // sort key values of the boundary row
+	Before bool          `json:"b,omitempty"` // page backwards from the boundary row
+}
+
+// cursorPage is a page of rows fetched with a cursor
+type cursorPage struct {
+	NextCursor string
+	PrevCursor string
+}
+
+// usesCursor reports whether a list request asked for cursor pagination
+func usesCursor(query url.Values) bool {
+	return query.Get("cursor") != "" || query.Get("pagination") == "cursor"
+}
+
+// encodeCursor serializes and signs a cursor
+func encodeCursor(c cursor, secret string) string {
+	payload, _ := json.Marshal(c)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + signCursor(encoded, secret)
+}
+
+// decodeCursor verifies and parses a cursor issued for the given sort order
+func decodeCursor(token string, fields []models.SortField, secret string) (*cursor, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(signCursor(parts[0], secret))) {
+		return nil, errInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, errInvalidCursor
+	}
+	if c.Sort != sortKey(fields) || len(c.Values) != len(fields) {
+		return nil, errInvalidCursor
+	}
+
+	// Timestamps travel as strings and must be compared as times
+	for i, field := range fields {
+		if s, ok := c.Values[i].(string); ok && strings.HasSuffix(field.Field, "_at") {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, errInvalidCursor
+			}
+			c.Values[i] = t
+		}
+	}
+	return &c, nil
+}
+
+// signCursor returns the HMAC of an encoded cursor
+func signCursor(encoded, secret string) string {
+	mac := hmac.New(sha256.New, []byte("cursor:"+secret))
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sortKey returns the canonical form of a sort order, e.g. "price,-id"
+func sortKey(fields []models.SortField) string {
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		if field.Desc {
+			parts[i] = "-" + field.Field
+		} else {
+			parts[i] = field.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// reverseSort flips the direction of every sort field
+func reverseSort(fields []models.SortField) []models.SortField {
+	reversed := make([]models.SortField, len(fields))
+	for i, field := range fields {
+		reversed[i] = models.SortField{Field: field.Field, Desc: !field.Desc}
+	}
+	return reversed
+}
+
+// paginateCursor fetches a page of rows using keyset pagination. fetch
+// returns up to limit rows in the given order, after the given sort key
+// values or from the start if they are nil. sortValue returns the value of a
+// sort field for a row.
+func paginateCursor[T any](fields []models.SortField, token string, limit int, secret string, fetch func(order []models.SortField, after []interface{}, limit int) ([]T, error), sortValue func(T, string) interface{}) ([]T, cursorPage, error) {
+	var page cursorPage
+
+	// Decode the cursor to start after its row
+	order := fields
+	var c *cursor
+	var after []interface{}
+	if token != "" {
+		var err error
+		if c, err = decodeCursor(token, fields, secret); err != nil {
+			return nil, page, err
+		}
+		if c.Before {
+			order = reverseSort(fields)
+		}
+		after = c.Values
+	}
+
+	// Fetch one extra row to know if there is another page
+	rows, err := fetch(order, after, limit+1)
+	if err != nil {
+		return nil, page, err
+	}
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	// Rows fetched backwards come out in reverse order
+	backwards := c != nil && c.Before
+	if backwards {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if len(rows) == 0 {
+		return rows, page, nil
+	}
+
+	boundary := func(row T, before bool) string {
+		values := make([]interface{}, len(fields))
+		for i, field := range fields {
+			values[i] = sortValue(row, field.Field)
+		}
+		return encodeCursor(cursor{Sort: sortKey(fields), Values: values, Before: before}, secret)
+	}
+
+	if hasMore || backwards {
+		page.NextCursor = boundary(rows[len(rows)-1], false)
+	}
+	if (backwards && hasMore) || (c != nil && !backwards) {
+		page.PrevCursor = boundary(rows[0], true)
+	}
+	return rows, page, nil
+}
+
+// setLinkHeader sets an RFC 8288 Link header pointing at the next and
+// previous pages. Each entry maps a relation type to the query parameters
+// that replace those of the current request.
+func setLinkHeader(w http.ResponseWriter, r *http.Request, links map[string]url.Values) {
+	var entries []string
+	for _, rel := range []string{"next", "prev"} {
+		params, ok := links[rel]
+		if !ok {
+			continue
+		}
+		query := r.URL.Query()
+		for key, values := range params {
+			query[key] = values
+		}
+		for key, values := range query {
+			if len(values) == 0 || values[0] == "" {
+				query.Del(key)
+			}
+		}
+		entries = append(entries, fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel))
+	}
+	if len(entries) > 0 {
+		w.Header().Set("Link", strings.Join(entries, ", "))
+	}
+}
+
+// cursorLinks returns the Link header entries for a cursor page
+func cursorLinks(page cursorPage) map[string]url.Values {
+	links := make(map[string]url.Values)
+	if page.NextCursor != "" {
+		links["next"] = url.Values{"cursor": {page.NextCursor}, "pagination": nil}
+	}
+	if page.PrevCursor != "" {
+		links["prev"] = url.Values{"cursor": {page.PrevCursor}, "pagination": nil}
+	}
+	return links
+}
+
+// offsetLinks returns the Link header entries for an offset page
+func offsetLinks(limit, offset, total int) map[string]url.Values {
+	links := make(map[string]url.Values)
+	if offset+limit < total {
+		links["next"] = url.Values{"offset": {strconv.Itoa(offset + limit)}}
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links["prev"] = url.Values{"offset": {strconv.Itoa(prev)}}
+	}
+	return links
+}
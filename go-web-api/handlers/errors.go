@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/niphawanphoopha/go-web-api/logging"
+)
+
+// errModified is returned when a versioned write loses a race with a
+// concurrent update
+var errModified = errors.New("resource was modified concurrently")
+
+// statusError is an error reported to the client with its own status code
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// writeError responds with the status of a statusError, with a conflict for
+// errModified, and with 500 and the fallback message for any other error
+func writeError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	if errors.Is(err, errModified) {
+		writeConflict(w, r)
+		return
+	}
+	status, message := errorStatus(err, fallback)
+	if status >= http.StatusInternalServerError {
+		serverError(w, r, err, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// serverError logs an error with the request's logger and responds 500 with
+// a message that does not reveal it
+func serverError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	logging.FromContext(r.Context()).Error(message, "error", err)
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+// errorStatus returns the status code and message an error is reported with
+func errorStatus(err error, fallback string) (int, string) {
+	var serr *statusError
+	switch {
+	case errors.As(err, &serr):
+		return serr.status, serr.message
+	case errors.Is(err, errModified):
+		return http.StatusConflict, "Resource was modified concurrently, retry the request"
+	}
+	return http.StatusInternalServerError, fallback
+}
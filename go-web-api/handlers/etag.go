@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// etag returns the entity tag of a resource version
+func etag(version uint) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// notModified writes a 304 response and returns true if the request's
+// If-None-Match header matches the current entity tag
+func notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !matchesETag(header, tag, true) {
+		return false
+	}
+	w.Header().Set("ETag", tag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// checkIfMatch writes a 412 response and returns false if the request has an
+// If-Match header that does not match the current entity tag
+func checkIfMatch(w http.ResponseWriter, r *http.Request, tag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || matchesETag(header, tag, false) {
+		return true
+	}
+	http.Error(w, "Resource has been modified", http.StatusPreconditionFailed)
+	return false
+}
+
+// matchesETag checks a comma separated list of entity tags, or "*", against
+// a tag. Weak comparison ignores the W/ prefix, as required for If-None-Match.
+func matchesETag(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeConflict reports a write that lost a race with a concurrent update:
+// 412 if the client sent If-Match, 409 otherwise
+func writeConflict(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("If-Match") != "" {
+		http.Error(w, "Resource has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	http.Error(w, "Resource was modified concurrently, retry the request", http.StatusConflict)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/events"
+	"github.com/niphawanphoopha/go-web-api/middleware"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// eventRetry is how long clients wait before reconnecting, in milliseconds
+const eventRetry = 3000
+
+// StreamEvents streams changes to the items and todos the caller can see as
+// Server-Sent Events. Clients resume after a reconnect with the Last-Event-ID
+// header or the last_event_id query parameter; if the events after that ID
+// are no longer kept, a "reset" event tells the client to reload instead.
+func StreamEvents(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("config").(*config.Config)
+
+	// Get user from context
+	claims, ok := r.Context().Value("user").(*middleware.Claims)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse the ID of the last event the client received
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var lastID uint64
+	resume := lastEventID != ""
+	if resume {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	// Subscribe before replaying so no event falls in between
+	sub := events.Default.Subscribe()
+	defer events.Default.Unsubscribe(sub)
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		serverError(w, r, err, "Failed to start event stream")
+		return
+	}
+
+	// Start the stream
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry)
+
+	// Replay the events the client missed
+	if resume {
+		missed, ok := events.Default.Since(lastID)
+		if !ok {
+			fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		}
+		for _, event := range missed {
+			if !writeEvent(w, claims, event) {
+				return
+			}
+			lastID = event.ID
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(time.Duration(cfg.EventHeartbeat) * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, open := <-sub.C:
+			// The subscriber fell behind; the client resumes from the log
+			if !open {
+				return
+			}
+			// Skip events already sent while replaying
+			if event.ID <= lastID {
+				continue
+			}
+			if !writeEvent(w, claims, event) {
+				return
+			}
+			lastID = event.ID
+
+		case <-heartbeat.C:
+			// End the stream once the token stops being valid
+			if claims.ExpiresAt != 0 && time.Now().Unix() > claims.ExpiresAt {
+				return
+			}
+			if revoked, err := middleware.Revocations.IsRevoked(claims); err != nil || revoked {
+				return
+			}
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes an event to the stream if the caller can see it. It
+// returns false if the stream should end.
+func writeEvent(w http.ResponseWriter, claims *middleware.Claims, event events.Event) bool {
+	visible, err := eventVisible(claims, event)
+	if err != nil {
+		return false
+	}
+	if !visible {
+		return true
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return false
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err == nil
+}
+
+// eventVisible checks if the caller can see an event. Items are visible to
+// their owner and to callers who can manage all items; todos to everyone who
+// can read them.
+func eventVisible(claims *middleware.Claims, event events.Event) (bool, error) {
+	switch event.Topic {
+	case events.TopicItems:
+		if event.OwnerID == claims.UserID {
+			return middleware.HasPermission(claims, models.PermItemsRead)
+		}
+		return middleware.HasPermission(claims, models.PermItemsAdmin)
+	case events.TopicTodos:
+		return middleware.HasPermission(claims, models.PermTodosRead)
+	}
+	return false, nil
+}
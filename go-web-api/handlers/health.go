@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/database"
+)
+
+// HealthResponse is the body of the liveness and readiness endpoints
+type HealthResponse struct {
+	Status string                 `json:"status"` // "ok" or "unavailable"
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the outcome of one readiness check
+type CheckResult struct {
+	Status    string  `json:"status"` // "ok" or "failed"
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// readinessCheck is a dependency the API needs to serve requests
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// readinessChecks are run by Readyz, each with HEALTH_CHECK_TIMEOUT, unless
+// the memory store is used, which depends on nothing
+var readinessChecks = []readinessCheck{
+	{"database", pingDatabase},
+	{"migrations", checkMigrations},
+}
+
+// Livez reports that the process is up and serving requests. It checks no
+// dependencies, so an orchestrator does not restart the API when the
+// database is down.
+func Livez(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthResponse{Status: "ok"}, http.StatusOK)
+}
+
+// Readyz runs the readiness checks in parallel and reports each one's status
+// and latency. It responds 503 if any check fails or times out, so traffic is
+// only routed to the API while it can serve it.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("config").(*config.Config)
+	timeout := time.Duration(cfg.HealthCheckTimeout) * time.Second
+
+	// Run the checks
+	checks := readinessChecks
+	if cfg.Storage == "memory" {
+		checks = nil
+	}
+	response := HealthResponse{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c readinessCheck) {
+			defer wg.Done()
+			result := runCheck(r.Context(), c, timeout)
+			mu.Lock()
+			defer mu.Unlock()
+			response.Checks[c.name] = result
+			if result.Status != "ok" {
+				response.Status = "unavailable"
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	// Return the results
+	status := http.StatusOK
+	if response.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, response, status)
+}
+
+// runCheck runs a readiness check, giving up on it after the timeout
+func runCheck(ctx context.Context, c readinessCheck, timeout time.Duration) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.check(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	result := CheckResult{Status: "ok", LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// pingDatabase checks that a pooled database connection answers
+func pingDatabase(ctx context.Context) error {
+	return database.DB.DB().PingContext(ctx)
+}
+
+// checkMigrations checks that the database schema is up to date, which it is
+// not while another replica is still migrating
+func checkMigrations(ctx context.Context) error {
+	return database.CheckSchema(ctx)
+}
+
+// writeHealth writes a health response that is never cached
+func writeHealth(w http.ResponseWriter, response HealthResponse, status int) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -2,63 +2,113 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
-	"github.com/gorilla/mux"
-	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/middleware"
 	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
 )
 
-// GetItems responds with the list of all items as JSON.
+// GetItems responds with a page of the caller's items as JSON. The list can
+// be filtered with q, type, moved, min_price, max_price, created_after and
+// created_before and ordered with sort. Pages are selected with limit and offset, or with
+// the signed cursors returned when pagination=cursor is passed. Users with the items:admin permission can pass
+// scope=all to list every user's items, or owner_id to list the items of a
+// specific user.
 func GetItems(w http.ResponseWriter, r *http.Request) {
-	var items []models.Item
+	items := []models.Item{}
 	
 	// Get query parameters
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
-	
-	// Set default values
-	if limit <= 0 {
-		limit = 10
+	filter, err := parseItemFilter(r.URL.Query(), models.ItemSortFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if offset < 0 {
-		offset = 0
+	
+	// Scope the query to the caller's items unless an admin asks otherwise
+	if !scopeItemFilter(w, r, &filter) {
+		return
 	}
 	
-	// Query the database
-	query := database.DB.Model(&models.Item{})
+	// Count the matching items before paginating
+	store := r.Context().Value("store").(*storage.Store)
+	total, err := store.Items.Count(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch items")
+		return
+	}
 	
-	// Apply pagination
-	query = query.Limit(limit).Offset(offset)
+	response := ListResponse{Total: total, Limit: filter.Limit}
 	
-	// Execute the query
-	if err := query.Find(&items).Error; err != nil {
-		http.Error(w, "Failed to fetch items", http.StatusInternalServerError)
-		return
+	if usesCursor(r.URL.Query()) {
+		// Apply keyset pagination
+		cfg := r.Context().Value("config").(*config.Config)
+		fetch := func(order []models.SortField, after []interface{}, limit int) ([]models.Item, error) {
+			page := filter
+			page.Sort, page.After, page.Limit, page.Offset = order, after, limit, 0
+			return store.Items.List(r.Context(), page)
+		}
+		page, cursors, err := paginateCursor(filter.Sort, r.URL.Query().Get("cursor"), filter.Limit, cfg.CursorSecret.Value(), fetch, models.ItemSortValue)
+		if err == errInvalidCursor {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err, "Failed to fetch items")
+			return
+		}
+		items = page
+		response.NextCursor = cursors.NextCursor
+		response.PrevCursor = cursors.PrevCursor
+		setLinkHeader(w, r, cursorLinks(cursors))
+	} else {
+		// Apply sorting and offset pagination
+		if items, err = store.Items.List(r.Context(), filter); err != nil {
+			serverError(w, r, err, "Failed to fetch items")
+			return
+		}
+		response.Offset = &filter.Offset
+		setLinkHeader(w, r, offsetLinks(filter.Limit, filter.Offset, total))
 	}
+	response.Items = items
 	
 	// Return the items
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(items)
+	json.NewEncoder(w).Encode(response)
 }
 
 // GetItemByID locates the item whose ID value matches the id
 // parameter sent by the client, then returns that item as a response.
 func GetItemByID(w http.ResponseWriter, r *http.Request) {
-	// Get the ID from the URL
-	vars := mux.Vars(r)
-	id := vars["id"]
+	// Find the item in the store
+	item, ok := findItem(w, r)
+	if !ok {
+		return
+	}
 	
-	// Find the item in the database
-	var item models.Item
-	if database.DB.Where("id = ?", id).First(&item).RecordNotFound() {
+	// Items of other users are hidden unless the caller can manage them
+	allowed, err := canAccessItem(r, item)
+	if err != nil {
+		serverError(w, r, err, "Failed to check permissions")
+		return
+	}
+	if !allowed {
 		http.Error(w, "Item not found", http.StatusNotFound)
 		return
 	}
 	
+	// Skip the body if the client already has this version
+	if notModified(w, r, etag(item.Version)) {
+		return
+	}
+	
 	// Return the item
+	w.Header().Set("ETag", etag(item.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(item)
@@ -79,14 +129,32 @@ func CreateItem(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Title and price are required", http.StatusBadRequest)
 		return
 	}
+	if err := validateExpiry(item.ExpiresAt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	// The item belongs to the user who creates it
+	claims, ok := r.Context().Value("user").(*middleware.Claims)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	item.ID = 0
+	item.OwnerID = claims.UserID
+	item.DeletedAt = nil
+	item.MovedAt = nil
+	item.ReturnsAt = nil
 	
-	// Save the item to the database
-	if err := database.DB.Create(&item).Error; err != nil {
-		http.Error(w, "Failed to create item", http.StatusInternalServerError)
+	// Save the item to the store
+	store := r.Context().Value("store").(*storage.Store)
+	if err := store.Items.Create(r.Context(), &item); err != nil {
+		serverError(w, r, err, "Failed to create item")
 		return
 	}
 	
 	// Return the created item
+	w.Header().Set("ETag", etag(item.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(item)
@@ -94,14 +162,25 @@ func CreateItem(w http.ResponseWriter, r *http.Request) {
 
 // UpdateItem updates an item from JSON received in the request body.
 func UpdateItem(w http.ResponseWriter, r *http.Request) {
-	// Get the ID from the URL
-	vars := mux.Vars(r)
-	id := vars["id"]
+	// Find the item in the store
+	item, ok := findItem(w, r)
+	if !ok {
+		return
+	}
 	
-	// Find the item in the database
-	var item models.Item
-	if database.DB.Where("id = ?", id).First(&item).RecordNotFound() {
-		http.Error(w, "Item not found", http.StatusNotFound)
+	// Only the owner can change the item unless the caller can manage all items
+	allowed, err := canAccessItem(r, item)
+	if err != nil {
+		serverError(w, r, err, "Failed to check permissions")
+		return
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(item.Version)) {
 		return
 	}
 	
@@ -112,39 +191,204 @@ func UpdateItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Update the item
-	item.Title = updatedItem.Title
-	item.Description = updatedItem.Description
-	item.Price = updatedItem.Price
+	// Validate request
+	if expiryChanged(item.ExpiresAt, updatedItem.ExpiresAt) {
+		if err := validateExpiry(updatedItem.ExpiresAt); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	
+	// Save the updated item to the store unless it changed since it was read
+	store := r.Context().Value("store").(*storage.Store)
+	saved, err := store.Items.Update(r.Context(), item, map[string]interface{}{
+		"title":       updatedItem.Title,
+		"description": updatedItem.Description,
+		"price":       updatedItem.Price,
+		"type":        updatedItem.Type,
+		"expires_at":  updatedItem.ExpiresAt,
+	})
+	if err != nil {
+		serverError(w, r, err, "Failed to update item")
+		return
+	}
+	if !saved {
+		writeConflict(w, r)
+		return
+	}
+	
+	// Return the updated item
+	w.Header().Set("ETag", etag(item.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(item)
+}
+
+// PatchItem partially updates an item with a JSON Merge Patch or a JSON
+// Patch. Only the title, description, price, type and expiry time can be
+// patched.
+func PatchItem(w http.ResponseWriter, r *http.Request) {
+	// Find the item in the store
+	item, ok := findItem(w, r)
+	if !ok {
+		return
+	}
+	
+	// Only the owner can change the item unless the caller can manage all items
+	allowed, err := canAccessItem(r, item)
+	if err != nil {
+		serverError(w, r, err, "Failed to check permissions")
+		return
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(item.Version)) {
+		return
+	}
 	
-	// Save the updated item to the database
-	if err := database.DB.Save(&item).Error; err != nil {
-		http.Error(w, "Failed to update item", http.StatusInternalServerError)
+	// Apply the patch to the patchable fields
+	doc, err := applyPatch(r, map[string]interface{}{
+		"title":       item.Title,
+		"description": item.Description,
+		"price":       item.Price,
+		"type":        item.Type,
+		"expires_at":  formatExpiry(item.ExpiresAt),
+	})
+	if err != nil {
+		writePatchError(w, err)
 		return
 	}
 	
+	// Validate the patched fields
+	changes, err := itemPatchChanges(item, doc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	
+	// Save the patched item to the store unless it changed since it was read.
+	// A patch changing nothing keeps the version, so other clients' entity
+	// tags stay valid.
+	if !itemUnchanged(item, changes) {
+		store := r.Context().Value("store").(*storage.Store)
+		saved, err := store.Items.Update(r.Context(), item, changes)
+		if err != nil {
+			serverError(w, r, err, "Failed to update item")
+			return
+		}
+		if !saved {
+			writeConflict(w, r)
+			return
+		}
+	}
+	
 	// Return the updated item
+	w.Header().Set("ETag", etag(item.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(item)
 }
 
+// itemPatchChanges validates a patched item document and returns the column
+// values to save. The expiry time is an RFC 3339 string, or empty for none.
+func itemPatchChanges(item *models.Item, doc map[string]interface{}) (map[string]interface{}, error) {
+	title, err := patchString(doc, "title")
+	if err != nil {
+		return nil, err
+	}
+	if title == "" {
+		return nil, errors.New("title is required")
+	}
+	description, err := patchString(doc, "description")
+	if err != nil {
+		return nil, err
+	}
+	price, err := patchNumber(doc, "price")
+	if err != nil {
+		return nil, err
+	}
+	if price <= 0 {
+		return nil, errors.New("price must be greater than zero")
+	}
+	itemType, err := patchString(doc, "type")
+	if err != nil {
+		return nil, err
+	}
+	expiry, err := patchString(doc, "expires_at")
+	if err != nil {
+		return nil, err
+	}
+	
+	var expiresAt *time.Time
+	if expiry != "" {
+		t, err := time.Parse(time.RFC3339, expiry)
+		if err != nil {
+			return nil, errors.New("expires_at must be an RFC 3339 time")
+		}
+		expiresAt = &t
+	}
+	if expiryChanged(item.ExpiresAt, expiresAt) {
+		if err := validateExpiry(expiresAt); err != nil {
+			return nil, err
+		}
+	}
+	
+	return map[string]interface{}{
+		"title":       title,
+		"description": description,
+		"price":       price,
+		"type":        itemType,
+		"expires_at":  expiresAt,
+	}, nil
+}
+
+// itemUnchanged reports whether saving the changes of a patch would leave the
+// item as it is
+func itemUnchanged(item *models.Item, changes map[string]interface{}) bool {
+	return changes["title"] == item.Title &&
+		changes["description"] == item.Description &&
+		changes["price"] == item.Price &&
+		changes["type"] == item.Type &&
+		!expiryChanged(item.ExpiresAt, changes["expires_at"].(*time.Time))
+}
+
 // DeleteItem removes an item from the database.
 func DeleteItem(w http.ResponseWriter, r *http.Request) {
-	// Get the ID from the URL
-	vars := mux.Vars(r)
-	id := vars["id"]
+	// Find the item in the store
+	item, ok := findItem(w, r)
+	if !ok {
+		return
+	}
 	
-	// Find the item in the database
-	var item models.Item
-	if database.DB.Where("id = ?", id).First(&item).RecordNotFound() {
-		http.Error(w, "Item not found", http.StatusNotFound)
+	// Only the owner can change the item unless the caller can manage all items
+	allowed, err := canAccessItem(r, item)
+	if err != nil {
+		serverError(w, r, err, "Failed to check permissions")
+		return
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 	
-	// Delete the item from the database
-	if err := database.DB.Delete(&item).Error; err != nil {
-		http.Error(w, "Failed to delete item", http.StatusInternalServerError)
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(item.Version)) {
+		return
+	}
+	
+	// Move the item to the trash unless it changed since it was read
+	store := r.Context().Value("store").(*storage.Store)
+	deleted, err := store.Items.Delete(r.Context(), item)
+	if err != nil {
+		serverError(w, r, err, "Failed to delete item")
+		return
+	}
+	if !deleted {
+		writeConflict(w, r)
 		return
 	}
 	
@@ -152,4 +396,63 @@ func DeleteItem(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Item deleted"})
-} 
\ No newline at end of file
+}
+
+// scopeItemFilter limits an item filter to the caller's items, or to the
+// items selected by the scope=all and owner_id parameters for callers with the
+// items:admin permission. It writes an error response and returns false if
+// the request is not allowed.
+func scopeItemFilter(w http.ResponseWriter, r *http.Request, filter *models.ItemFilter) bool {
+	// Get the claims from the context
+	claims, ok := r.Context().Value("user").(*middleware.Claims)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	
+	scope := r.URL.Query().Get("scope")
+	ownerID := r.URL.Query().Get("owner_id")
+	if scope != "all" && ownerID == "" {
+		filter.OwnerID = &claims.UserID
+		return true
+	}
+	
+	allowed, err := middleware.HasPermission(claims, models.PermItemsAdmin)
+	if err != nil {
+		serverError(w, r, err, "Failed to check permissions")
+		return false
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	if ownerID != "" {
+		id, err := strconv.ParseUint(ownerID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid owner_id", http.StatusBadRequest)
+			return false
+		}
+		owner := uint(id)
+		filter.OwnerID = &owner
+	}
+	return true
+}
+
+// canAccessItem checks if the caller owns the item or has the items:admin
+// permission
+func canAccessItem(r *http.Request, item *models.Item) (bool, error) {
+	claims, ok := r.Context().Value("user").(*middleware.Claims)
+	if !ok {
+		return false, nil
+	}
+	return claimsCanAccessItem(claims, item)
+}
+
+// claimsCanAccessItem checks if the user in the claims owns the item or has
+// the items:admin permission
+func claimsCanAccessItem(claims *middleware.Claims, item *models.Item) (bool, error) {
+	if item.IsOwnedBy(claims.UserID) {
+		return true, nil
+	}
+	return middleware.HasPermission(claims, models.PermItemsAdmin)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/middleware"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// serve calls a handler with a request as the router hands it over: with
+// the configuration, the store and the caller's claims in its context, and
+// the route variables set. Headers are given as name and value pairs.
+func serve(handler http.HandlerFunc, store *storage.Store, userID uint, method, target, body string, vars map[string]string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	ctx := context.WithValue(r.Context(), "config", config.Default())
+	ctx = context.WithValue(ctx, "store", store)
+	ctx = context.WithValue(ctx, "user", &middleware.Claims{UserID: userID, Role: models.RoleUser})
+
+	w := httptest.NewRecorder()
+	handler(w, mux.SetURLVars(r.WithContext(ctx), vars))
+	return w
+}
+
+// expectStatus fails the test if a response does not have the wanted status
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d: %s", w.Code, want, w.Body.String())
+	}
+}
+
+func TestItemLifecycle(t *testing.T) {
+	store := storage.NewMemoryStore()
+	id := map[string]string{"id": "1"}
+
+	// Create an item
+	w := serve(CreateItem, store, 1, "POST", "/api/items", `{"title":"Lamp","price":12.5}`, nil)
+	expectStatus(t, w, http.StatusCreated)
+	var item models.Item
+	if err := json.NewDecoder(w.Body).Decode(&item); err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != 1 || item.OwnerID != 1 || item.Version != 1 {
+		t.Fatalf("created item = %+v, want ID 1 owned by user 1 at version 1", item)
+	}
+
+	// Read it, and skip the body when the client has the current version
+	w = serve(GetItemByID, store, 1, "GET", "/api/items/1", "", id)
+	expectStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("ETag"); got != `"1"` {
+		t.Errorf("ETag = %s, want \"1\"", got)
+	}
+	w = serve(GetItemByID, store, 1, "GET", "/api/items/1", "", id, "If-None-Match", `"1"`)
+	expectStatus(t, w, http.StatusNotModified)
+
+	// Update it, refusing a client that has not seen the current version
+	w = serve(UpdateItem, store, 1, "PUT", "/api/items/1", `{"title":"Desk lamp","price":15}`, id, "If-Match", `"2"`)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+	w = serve(UpdateItem, store, 1, "PUT", "/api/items/1", `{"title":"Desk lamp","price":15}`, id, "If-Match", `"1"`)
+	expectStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("ETag after updating = %s, want \"2\"", got)
+	}
+
+	// Move it to the trash, where it is hidden, and restore it
+	w = serve(DeleteItem, store, 1, "DELETE", "/api/items/1", "", id)
+	expectStatus(t, w, http.StatusOK)
+	w = serve(GetItemByID, store, 1, "GET", "/api/items/1", "", id)
+	expectStatus(t, w, http.StatusNotFound)
+	w = serve(RestoreItem, store, 1, "POST", "/api/items/1/restore", "", id)
+	expectStatus(t, w, http.StatusOK)
+	w = serve(GetItemByID, store, 1, "GET", "/api/items/1", "", id)
+	expectStatus(t, w, http.StatusOK)
+	if err := json.NewDecoder(w.Body).Decode(&item); err != nil {
+		t.Fatal(err)
+	}
+	if item.Title != "Desk lamp" || item.DeletedAt != nil {
+		t.Errorf("restored item = %+v, want the updated title and no deletion time", item)
+	}
+}
+
+func TestCreateItemValidation(t *testing.T) {
+	store := storage.NewMemoryStore()
+
+	for _, body := range []string{`{"price":5}`, `{"title":"Lamp"}`, `{"title":"Lamp","price":-1}`, `not json`} {
+		w := serve(CreateItem, store, 1, "POST", "/api/items", body, nil)
+		expectStatus(t, w, http.StatusBadRequest)
+	}
+}
+
+func TestGetItemsListsCallersItems(t *testing.T) {
+	store := storage.NewMemoryStore()
+	for _, owner := range []uint{1, 2, 1} {
+		w := serve(CreateItem, store, owner, "POST", "/api/items", `{"title":"Lamp","price":5}`, nil)
+		expectStatus(t, w, http.StatusCreated)
+	}
+
+	w := serve(GetItems, store, 1, "GET", "/api/items", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	var response struct {
+		Items []models.Item `json:"items"`
+		Total int           `json:"total"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 2 || len(response.Items) != 2 {
+		t.Fatalf("listed %d of %d items, want 2 of 2", len(response.Items), response.Total)
+	}
+	for _, item := range response.Items {
+		if item.OwnerID != 1 {
+			t.Errorf("listed item %d of user %d, want only user 1's", item.ID, item.OwnerID)
+		}
+	}
+}
+
+func TestPatchItemWithoutChangesKeepsVersion(t *testing.T) {
+	store := storage.NewMemoryStore()
+	id := map[string]string{"id": "1"}
+	w := serve(CreateItem, store, 1, "POST", "/api/items", `{"title":"Lamp","price":5}`, nil)
+	expectStatus(t, w, http.StatusCreated)
+
+	// Patching in the current values changes nothing
+	w = serve(PatchItem, store, 1, "PATCH", "/api/items/1", `{"title":"Lamp","price":5}`, id,
+		"Content-Type", "application/merge-patch+json", "If-Match", `"1"`)
+	expectStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("ETag"); got != `"1"` {
+		t.Errorf("ETag after an empty patch = %s, want \"1\"", got)
+	}
+
+	// A real change bumps the version
+	w = serve(PatchItem, store, 1, "PATCH", "/api/items/1", `{"price":6}`, id,
+		"Content-Type", "application/merge-patch+json", "If-Match", `"1"`)
+	expectStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("ETag after a patch = %s, want \"2\"", got)
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// MoveRequest represents the optional request body for moving an item
+type MoveRequest struct {
+	TTL *int `json:"ttl"` // Seconds until the item returns
+}
+
+// MoveItem moves an item into the column of its type. The item returns on
+// its own once the TTL from the request, or ITEM_MOVE_TTL, has passed.
+func MoveItem(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("config").(*config.Config)
+
+	// Find the item the caller may change
+	item, ok := findMovableItem(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request body, which may be empty
+	var req MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Move the item
+	ttl := cfg.ItemMoveTTL
+	if req.TTL != nil {
+		ttl = *req.TTL
+	}
+	store := r.Context().Value("store").(*storage.Store)
+	if err := moveItem(r.Context(), store.Items, item, ttl); err != nil {
+		writeError(w, r, err, "Failed to move item")
+		return
+	}
+
+	// Return the moved item
+	w.Header().Set("ETag", etag(item.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(item)
+}
+
+// MoveItemBack returns a moved item before its TTL has passed
+func MoveItemBack(w http.ResponseWriter, r *http.Request) {
+	// Find the item the caller may change
+	item, ok := findMovableItem(w, r)
+	if !ok {
+		return
+	}
+
+	// Move the item back
+	store := r.Context().Value("store").(*storage.Store)
+	if err := moveItemBack(r.Context(), store.Items, item); err != nil {
+		writeError(w, r, err, "Failed to move item back")
+		return
+	}
+
+	// Return the item
+	w.Header().Set("ETag", etag(item.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(item)
+}
+
+// moveItem moves an item into the column of its type for ttl seconds unless
+// it changed since it was read, and records the change
+func moveItem(ctx context.Context, items storage.ItemRepository, item *models.Item, ttl int) error {
+	// Validate the move
+	if ttl <= 0 {
+		return &statusError{http.StatusBadRequest, "ttl must be greater than zero"}
+	}
+	if item.Type == "" {
+		return &statusError{http.StatusUnprocessableEntity, "Item has no type to move to"}
+	}
+	if item.IsMoved() {
+		return &statusError{http.StatusConflict, "Item is already moved"}
+	}
+
+	// Move the item unless it changed since it was read
+	now := time.Now()
+	saved, err := items.Update(ctx, item, map[string]interface{}{
+		"moved_at":   now,
+		"returns_at": now.Add(time.Duration(ttl) * time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return errModified
+	}
+	return nil
+}
+
+// moveItemBack returns a moved item unless it changed since it was read, and
+// records the change
+func moveItemBack(ctx context.Context, items storage.ItemRepository, item *models.Item) error {
+	// Validate the move
+	if !item.IsMoved() {
+		return &statusError{http.StatusConflict, "Item is not moved"}
+	}
+
+	// Move the item back unless it changed since it was read
+	saved, err := items.Update(ctx, item, map[string]interface{}{
+		"moved_at":   nil,
+		"returns_at": nil,
+	})
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return errModified
+	}
+	return nil
+}
+
+// findMovableItem loads the item named in the URL and checks that the caller
+// can change it and has seen its current version. It writes an error
+// response and returns false otherwise.
+func findMovableItem(w http.ResponseWriter, r *http.Request) (*models.Item, bool) {
+	// Find the item in the store
+	item, ok := findItem(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	// Only the owner can move the item unless the caller can manage all items
+	allowed, err := canAccessItem(r, item)
+	if err != nil {
+		serverError(w, r, err, "Failed to check permissions")
+		return nil, false
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(item.Version)) {
+		return nil, false
+	}
+	return item, true
+}
+
+// validateExpiry checks that an expiry time, if set, is in the future
+func validateExpiry(expiresAt *time.Time) error {
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+// expiryChanged checks if two optional expiry times differ
+func expiryChanged(old, new *time.Time) bool {
+	if old == nil || new == nil {
+		return old != new
+	}
+	return !old.Equal(*new)
+}
+
+// formatExpiry formats an optional expiry time for a patch document, using
+// an empty string for none
+func formatExpiry(expiresAt *time.Time) string {
+	if expiresAt == nil {
+		return ""
+	}
+	return expiresAt.Format(time.RFC3339Nano)
+}
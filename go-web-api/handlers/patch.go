@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Media types accepted by PATCH endpoints
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+var (
+	// errUnsupportedPatch is returned for PATCH requests in an unknown format
+	errUnsupportedPatch = errors.New("unsupported patch format, use " + mergePatchType + " or " + jsonPatchType)
+	// errMalformedPatch is returned for patch documents that cannot be parsed
+	errMalformedPatch = errors.New("malformed patch document")
+)
+
+// patchOperation is a single JSON Patch (RFC 6902) operation
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyPatch applies the body of a PATCH request to a flat document holding
+// the patchable fields of a resource. Patches that touch any other field are
+// rejected, so the document doubles as the whitelist.
+func applyPatch(r *http.Request, doc map[string]interface{}) (map[string]interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case mergePatchType:
+		return applyMergePatch(r, doc)
+	case jsonPatchType:
+		return applyJSONPatch(r, doc)
+	default:
+		return nil, errUnsupportedPatch
+	}
+}
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7396). Members set to null
+// are reset to their zero value, since the fields cannot be absent.
+func applyMergePatch(r *http.Request, doc map[string]interface{}) (map[string]interface{}, error) {
+	var patch map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return nil, errMalformedPatch
+	}
+
+	result := copyDocument(doc)
+	for key, value := range patch {
+		current, ok := result[key]
+		if !ok {
+			return nil, fmt.Errorf("field %q cannot be patched", key)
+		}
+		if value == nil {
+			value = reflect.Zero(reflect.TypeOf(current)).Interface()
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// applyJSONPatch applies a JSON Patch (RFC 6902). Only top-level paths exist,
+// and remove resets a field to its zero value.
+func applyJSONPatch(r *http.Request, doc map[string]interface{}) (map[string]interface{}, error) {
+	var operations []patchOperation
+	if err := json.NewDecoder(r.Body).Decode(&operations); err != nil {
+		return nil, errMalformedPatch
+	}
+
+	result := copyDocument(doc)
+	for i, op := range operations {
+		key, err := patchPath(result, op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %v", i, err)
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			var value interface{}
+			if len(op.Value) == 0 {
+				return nil, fmt.Errorf("operation %d: value is required", i)
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, fmt.Errorf("operation %d: invalid value", i)
+			}
+			if op.Op == "test" {
+				if !reflect.DeepEqual(result[key], value) {
+					return nil, fmt.Errorf("operation %d: test failed for %s", i, op.Path)
+				}
+				continue
+			}
+			result[key] = value
+		case "remove":
+			result[key] = reflect.Zero(reflect.TypeOf(result[key])).Interface()
+		case "copy", "move":
+			from, err := patchPath(result, op.From)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %v", i, err)
+			}
+			result[key] = result[from]
+			if op.Op == "move" && from != key {
+				result[from] = reflect.Zero(reflect.TypeOf(result[from])).Interface()
+			}
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+		}
+	}
+	return result, nil
+}
+
+// patchPath resolves a JSON Pointer to a top-level field of the document
+func patchPath(doc map[string]interface{}, path string) (string, error) {
+	if !strings.HasPrefix(path, "/") || strings.Count(path, "/") != 1 {
+		return "", fmt.Errorf("invalid path %q", path)
+	}
+	key := strings.NewReplacer("~1", "/", "~0", "~").Replace(path[1:])
+	if _, ok := doc[key]; !ok {
+		return "", fmt.Errorf("field %q cannot be patched", key)
+	}
+	return key, nil
+}
+
+// copyDocument returns a shallow copy of a patch document
+func copyDocument(doc map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(doc))
+	for key, value := range doc {
+		result[key] = value
+	}
+	return result
+}
+
+// patchString reads a string field from a patched document
+func patchString(doc map[string]interface{}, key string) (string, error) {
+	value, ok := doc[key].(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return value, nil
+}
+
+// patchNumber reads a number field from a patched document
+func patchNumber(doc map[string]interface{}, key string) (float64, error) {
+	value, ok := doc[key].(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s must be a number", key)
+	}
+	return value, nil
+}
+
+// writePatchError writes the response for a patch that could not be applied
+func writePatchError(w http.ResponseWriter, err error) {
+	switch err {
+	case errUnsupportedPatch:
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	case errMalformedPatch:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+}
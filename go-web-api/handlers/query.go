@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+const (
+	// defaultLimit is the page size used when no limit is given
+	defaultLimit = 10
+	// maxLimit is the largest page size a client can ask for
+	maxLimit = 100
+)
+
+// ListResponse is the envelope returned by list endpoints. Offset is set in
+// offset mode and the cursors in cursor mode.
+type ListResponse struct {
+	Items      interface{} `json:"items"`
+	Total      int         `json:"total"`
+	Limit      int         `json:"limit"`
+	Offset     *int        `json:"offset,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+}
+
+// parsePagination reads the limit and offset query parameters
+func parsePagination(query url.Values) (limit, offset int, err error) {
+	limit, offset = defaultLimit, 0
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit: %q", v)
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset: %q", v)
+		}
+	}
+	return limit, offset, nil
+}
+
+// parseSort parses a comma separated list of fields, each optionally prefixed
+// with "-" for descending order, e.g. "price,-created_at". Only the allowed
+// fields are accepted, and "id" is appended as a tie-breaker so the order is
+// always stable.
+func parseSort(value string, allowed []string) ([]models.SortField, error) {
+	var fields []models.SortField
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		field := models.SortField{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if !contains(allowed, field.Field) {
+			return nil, fmt.Errorf("cannot sort by %q", field.Field)
+		}
+		if seen[field.Field] {
+			return nil, fmt.Errorf("duplicate sort field %q", field.Field)
+		}
+		seen[field.Field] = true
+		fields = append(fields, field)
+	}
+
+	if !seen["id"] {
+		fields = append(fields, models.SortField{Field: "id"})
+	}
+	return fields, nil
+}
+
+// parseItemFilter builds an ItemFilter from the query parameters of an
+// items list request, accepting the given sort fields
+func parseItemFilter(query url.Values, sortFields []string) (models.ItemFilter, error) {
+	var filter models.ItemFilter
+	var err error
+
+	if filter.Limit, filter.Offset, err = parsePagination(query); err != nil {
+		return filter, err
+	}
+
+	filter.Query = strings.TrimSpace(query.Get("q"))
+	filter.Type = query.Get("type")
+	
+	if v := query.Get("moved"); v != "" {
+		moved, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid moved: %q", v)
+		}
+		filter.Moved = &moved
+	}
+
+	if filter.MinPrice, err = parseFloatParam(query, "min_price"); err != nil {
+		return filter, err
+	}
+	if filter.MaxPrice, err = parseFloatParam(query, "max_price"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedAfter, err = parseTimeParam(query, "created_after"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseTimeParam(query, "created_before"); err != nil {
+		return filter, err
+	}
+
+	if filter.Sort, err = parseSort(query.Get("sort"), sortFields); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// parseTodoFilter builds a TodoFilter from the query parameters of a todos
+// list request
+func parseTodoFilter(query url.Values) (models.TodoFilter, error) {
+	var filter models.TodoFilter
+	var err error
+
+	if filter.Limit, filter.Offset, err = parsePagination(query); err != nil {
+		return filter, err
+	}
+
+	filter.Query = strings.TrimSpace(query.Get("q"))
+	filter.Type = query.Get("type")
+	filter.State = query.Get("state")
+	if filter.State != "" && filter.State != models.TodoStateMain && filter.State != models.TodoStateMoved {
+		return filter, fmt.Errorf("invalid state: %q", filter.State)
+	}
+
+	if filter.Sort, err = parseSort(query.Get("sort"), models.TodoSortFields); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// parseFloatParam parses an optional float query parameter
+func parseFloatParam(query url.Values, key string) (*float64, error) {
+	v := query.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q", key, v)
+	}
+	return &f, nil
+}
+
+// parseTimeParam parses an optional time query parameter given either as
+// RFC 3339 or as a date (YYYY-MM-DD)
+func parseTimeParam(query url.Values, key string) (*time.Time, error) {
+	v := query.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s: %q, expected RFC 3339 or YYYY-MM-DD", key, v)
+}
+
+// contains checks if a slice contains a string
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
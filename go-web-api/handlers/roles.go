@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/niphawanphoopha/go-web-api/middleware"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// RoleRequest represents the request body for creating or updating a role
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// AssignRoleRequest represents the request body for assigning a role to a user
+type AssignRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// GetPermissions returns all permissions known to the API
+func GetPermissions(w http.ResponseWriter, r *http.Request) {
+	store := r.Context().Value("store").(*storage.Store)
+	permissions, err := store.Roles.Permissions(r.Context())
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch permissions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(permissions)
+}
+
+// GetRoles returns all roles with their permissions
+func GetRoles(w http.ResponseWriter, r *http.Request) {
+	store := r.Context().Value("store").(*storage.Store)
+	roles, err := store.Roles.List(r.Context())
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch roles")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(roles)
+}
+
+// CreateRole creates a new role from a name and a list of permission names
+func CreateRole(w http.ResponseWriter, r *http.Request) {
+	var req RoleRequest
+
+	// Parse request body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Name == "" {
+		http.Error(w, "Role name is required", http.StatusBadRequest)
+		return
+	}
+	permissions, ok := findPermissions(w, r, req.Permissions)
+	if !ok {
+		return
+	}
+
+	// Check if the role already exists
+	store := r.Context().Value("store").(*storage.Store)
+	_, err := store.Roles.Find(r.Context(), req.Name)
+	if err == nil {
+		http.Error(w, "Role already exists", http.StatusConflict)
+		return
+	}
+	if err != storage.ErrNotFound {
+		serverError(w, r, err, "Failed to create role")
+		return
+	}
+
+	// Save the role to the store
+	role := models.Role{
+		Name:        req.Name,
+		Description: req.Description,
+		Permissions: permissions,
+	}
+	if err := store.Roles.Create(r.Context(), &role); err != nil {
+		serverError(w, r, err, "Failed to create role")
+		return
+	}
+	middleware.InvalidatePermissions()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+// UpdateRole replaces the description and permissions of a role
+func UpdateRole(w http.ResponseWriter, r *http.Request) {
+	// Get the name from the URL
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	// Find the role in the store
+	role, ok := findRole(w, r, name)
+	if !ok {
+		return
+	}
+
+	// Parse request body
+	var req RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// The admin role always holds every permission
+	if role.Name == models.RoleAdmin {
+		http.Error(w, "The admin role cannot be changed", http.StatusForbidden)
+		return
+	}
+	permissions, ok := findPermissions(w, r, req.Permissions)
+	if !ok {
+		return
+	}
+
+	// Update the role and its permissions
+	store := r.Context().Value("store").(*storage.Store)
+	role.Description = req.Description
+	role.Permissions = permissions
+	if err := store.Roles.Update(r.Context(), role); err != nil {
+		serverError(w, r, err, "Failed to update role")
+		return
+	}
+	middleware.InvalidatePermissions()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(role)
+}
+
+// DeleteRole deletes a role that is not assigned to any user
+func DeleteRole(w http.ResponseWriter, r *http.Request) {
+	// Get the name from the URL
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	// Find the role in the store
+	role, ok := findRole(w, r, name)
+	if !ok {
+		return
+	}
+
+	// Built-in roles cannot be deleted
+	if role.Name == models.RoleAdmin || role.Name == models.RoleUser {
+		http.Error(w, "Built-in roles cannot be deleted", http.StatusForbidden)
+		return
+	}
+
+	// Refuse to delete roles that are still assigned
+	store := r.Context().Value("store").(*storage.Store)
+	count, err := store.Users.Count(r.Context(), models.UserFilter{Role: role.Name})
+	if err != nil {
+		serverError(w, r, err, "Failed to delete role")
+		return
+	}
+	if count > 0 {
+		http.Error(w, "Role is assigned to users", http.StatusConflict)
+		return
+	}
+
+	// Delete the role and its permission links
+	if err := store.Roles.Delete(r.Context(), role); err != nil {
+		serverError(w, r, err, "Failed to delete role")
+		return
+	}
+	middleware.InvalidatePermissions()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role deleted"})
+}
+
+// AssignRole assigns a role to a user
+func AssignRole(w http.ResponseWriter, r *http.Request) {
+	// Find the user in the store
+	user, ok := findUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request body
+	var req AssignRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !roleExists(w, r, req.Role) {
+		return
+	}
+
+	if user.Role != req.Role {
+		// Update only the role unless the user changed since it was read
+		store := r.Context().Value("store").(*storage.Store)
+		saved, err := store.Users.Update(r.Context(), user, map[string]interface{}{"role": req.Role})
+		if err != nil {
+			serverError(w, r, err, "Failed to assign role")
+			return
+		}
+		if !saved {
+			writeConflict(w, r)
+			return
+		}
+
+		// Tokens carry the role, so revoke the ones issued with the old role
+		if err := middleware.Revocations.RevokeUser(user.ID); err != nil {
+			serverError(w, r, err, "Failed to revoke user tokens")
+			return
+		}
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// findPermissions loads the named permissions and writes a 400 response if
+// any of them does not exist
+func findPermissions(w http.ResponseWriter, r *http.Request, names []string) ([]models.Permission, bool) {
+	store := r.Context().Value("store").(*storage.Store)
+	permissions, err := store.Roles.FindPermissions(r.Context(), names)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch permissions")
+		return nil, false
+	}
+
+	found := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		found[p.Name] = true
+	}
+	for _, name := range names {
+		if !found[name] {
+			http.Error(w, "Unknown permission: "+name, http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	return permissions, true
+}
+
+// roleExists checks that a role with the given name exists and writes a 400
+// response if it does not
+func roleExists(w http.ResponseWriter, r *http.Request, name string) bool {
+	store := r.Context().Value("store").(*storage.Store)
+	_, err := store.Roles.Find(r.Context(), name)
+	if err == storage.ErrNotFound {
+		http.Error(w, "Unknown role: "+name, http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch role")
+		return false
+	}
+	return true
+}
+
+// findRole loads the named role and writes a 404 response if it does not
+// exist
+func findRole(w http.ResponseWriter, r *http.Request, name string) (*models.Role, bool) {
+	store := r.Context().Value("store").(*storage.Store)
+	role, err := store.Roles.Find(r.Context(), name)
+	if err == storage.ErrNotFound {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch role")
+		return nil, false
+	}
+	return role, true
+}
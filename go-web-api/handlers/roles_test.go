@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/niphawanphoopha/go-web-api/middleware"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// seedRoles seeds the permissions and built-in roles of a store, and
+// resolves permissions and revokes tokens with it like the server does
+func seedRoles(t *testing.T, store *storage.Store) {
+	t.Helper()
+	if err := store.Roles.Seed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	middleware.Roles = store.Roles
+	middleware.Revocations = middleware.NewRevocationStore(store)
+	middleware.InvalidatePermissions()
+}
+
+func TestRoleLifecycle(t *testing.T) {
+	store := storage.NewMemoryStore()
+	seedRoles(t, store)
+	createUsers(t, store, "alice")
+	name := map[string]string{"name": "editor"}
+	claims := &middleware.Claims{UserID: 1, Role: "editor"}
+
+	// Create a role that can only read items
+	w := serve(CreateRole, store, 1, "POST", "/api/admin/roles", `{"name":"editor","permissions":["items:read"]}`, nil)
+	expectStatus(t, w, http.StatusCreated)
+	w = serve(CreateRole, store, 1, "POST", "/api/admin/roles", `{"name":"editor"}`, nil)
+	expectStatus(t, w, http.StatusConflict)
+	if ok, err := middleware.HasPermission(claims, models.PermItemsWrite); err != nil || ok {
+		t.Fatalf("HasPermission(items:write) = %v, %v, want false", ok, err)
+	}
+
+	// Grant it writing too
+	w = serve(UpdateRole, store, 1, "PUT", "/api/admin/roles/editor", `{"permissions":["items:read","items:write"]}`, name)
+	expectStatus(t, w, http.StatusOK)
+	if ok, err := middleware.HasPermission(claims, models.PermItemsWrite); err != nil || !ok {
+		t.Fatalf("HasPermission(items:write) = %v, %v, want true", ok, err)
+	}
+
+	// Roles cannot be deleted while assigned
+	w = serve(AssignRole, store, 1, "PUT", "/api/admin/users/1/role", `{"role":"editor"}`, map[string]string{"id": "1"})
+	expectStatus(t, w, http.StatusOK)
+	w = serve(DeleteRole, store, 1, "DELETE", "/api/admin/roles/editor", "", name)
+	expectStatus(t, w, http.StatusConflict)
+	w = serve(AssignRole, store, 1, "PUT", "/api/admin/users/1/role", `{"role":"user"}`, map[string]string{"id": "1"})
+	expectStatus(t, w, http.StatusOK)
+	w = serve(DeleteRole, store, 1, "DELETE", "/api/admin/roles/editor", "", name)
+	expectStatus(t, w, http.StatusOK)
+	w = serve(UpdateRole, store, 1, "PUT", "/api/admin/roles/editor", `{}`, name)
+	expectStatus(t, w, http.StatusNotFound)
+}
+
+func TestBuiltInRolesAreProtected(t *testing.T) {
+	store := storage.NewMemoryStore()
+	seedRoles(t, store)
+
+	w := serve(UpdateRole, store, 1, "PUT", "/api/admin/roles/admin", `{"permissions":[]}`, map[string]string{"name": "admin"})
+	expectStatus(t, w, http.StatusForbidden)
+	w = serve(DeleteRole, store, 1, "DELETE", "/api/admin/roles/user", "", map[string]string{"name": "user"})
+	expectStatus(t, w, http.StatusForbidden)
+	w = serve(CreateRole, store, 1, "POST", "/api/admin/roles", `{"name":"ghost","permissions":["nothing:read"]}`, nil)
+	expectStatus(t, w, http.StatusBadRequest)
+}
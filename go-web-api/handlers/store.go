@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// findItem loads the item named in the URL and writes a 404 response if it
+// does not exist or is in the trash
+func findItem(w http.ResponseWriter, r *http.Request) (*models.Item, bool) {
+	store := r.Context().Value("store").(*storage.Store)
+	return findByID(w, r, store.Items.Find, "Item not found", "Failed to fetch item")
+}
+
+// findTrashedItem loads the item named in the URL and writes a 404 response
+// if it is not in the trash
+func findTrashedItem(w http.ResponseWriter, r *http.Request) (*models.Item, bool) {
+	store := r.Context().Value("store").(*storage.Store)
+	return findByID(w, r, store.Items.FindDeleted, "Item not found in trash", "Failed to fetch item")
+}
+
+// findUser loads the user named in the URL and writes a 404 response if it
+// does not exist or is in the trash
+func findUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	store := r.Context().Value("store").(*storage.Store)
+	return findByID(w, r, store.Users.Find, "User not found", "Failed to fetch user")
+}
+
+// findTrashedUser loads the user named in the URL and writes a 404 response
+// if it is not in the trash
+func findTrashedUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	store := r.Context().Value("store").(*storage.Store)
+	return findByID(w, r, store.Users.FindDeleted, "User not found in trash", "Failed to fetch user")
+}
+
+// findByID looks up the row whose ID is in the URL. It writes a 404 response
+// with the notFound message if the ID is invalid or the row does not exist,
+// and a 500 response with the failed message on other errors.
+func findByID[T any](w http.ResponseWriter, r *http.Request, find func(context.Context, uint) (*T, error), notFound, failed string) (*T, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, notFound, http.StatusNotFound)
+		return nil, false
+	}
+
+	row, err := find(r.Context(), uint(id))
+	if err == storage.ErrNotFound {
+		http.Error(w, notFound, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		serverError(w, r, err, failed)
+		return nil, false
+	}
+	return row, true
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// TodoTypeRequest represents the request body for creating or updating a
+// todo type
+type TodoTypeRequest struct {
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+}
+
+// GetTodoTypes returns the todo columns in display order
+func GetTodoTypes(w http.ResponseWriter, r *http.Request) {
+	store := r.Context().Value("store").(*storage.Store)
+	types, err := store.TodoTypes.List(r.Context())
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch todo types")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(types)
+}
+
+// CreateTodoType adds a todo column
+func CreateTodoType(w http.ResponseWriter, r *http.Request) {
+	var req TodoTypeRequest
+
+	// Parse request body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Name == "" {
+		http.Error(w, "Todo type name is required", http.StatusBadRequest)
+		return
+	}
+
+	// Check if the type already exists
+	if !todoTypeFree(w, r, req.Name, "Failed to create todo type") {
+		return
+	}
+
+	// Save the type to the store
+	store := r.Context().Value("store").(*storage.Store)
+	todoType := models.TodoType{Name: req.Name, Position: req.Position}
+	if err := store.TodoTypes.Create(r.Context(), &todoType); err != nil {
+		serverError(w, r, err, "Failed to create todo type")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(todoType)
+}
+
+// UpdateTodoType renames or reorders a todo column. Renaming it also renames
+// the type of its todos.
+func UpdateTodoType(w http.ResponseWriter, r *http.Request) {
+	// Get the name from the URL
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	// Find the type in the store
+	todoType, ok := findTodoType(w, r, name)
+	if !ok {
+		return
+	}
+
+	// Parse request body
+	var req TodoTypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Name == "" {
+		http.Error(w, "Todo type name is required", http.StatusBadRequest)
+		return
+	}
+	if req.Name != todoType.Name && !todoTypeFree(w, r, req.Name, "Failed to update todo type") {
+		return
+	}
+
+	// Update the type and the todos that use it
+	store := r.Context().Value("store").(*storage.Store)
+	todoType.Name = req.Name
+	todoType.Position = req.Position
+	if err := store.TodoTypes.Update(r.Context(), todoType, name); err != nil {
+		serverError(w, r, err, "Failed to update todo type")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(todoType)
+}
+
+// DeleteTodoType deletes a todo column that no todo uses
+func DeleteTodoType(w http.ResponseWriter, r *http.Request) {
+	// Get the name from the URL
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	// Find the type in the store
+	todoType, ok := findTodoType(w, r, name)
+	if !ok {
+		return
+	}
+
+	// Refuse to delete types that are still used
+	store := r.Context().Value("store").(*storage.Store)
+	count, err := store.Todos.Count(r.Context(), models.TodoFilter{Type: todoType.Name})
+	if err != nil {
+		serverError(w, r, err, "Failed to delete todo type")
+		return
+	}
+	if count > 0 {
+		http.Error(w, "Todo type is used by todos", http.StatusConflict)
+		return
+	}
+
+	// Delete the type
+	if err := store.TodoTypes.Delete(r.Context(), todoType); err != nil {
+		serverError(w, r, err, "Failed to delete todo type")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Todo type deleted"})
+}
+
+// todoTypeExists checks that a todo type with the given name exists and
+// writes a 400 response if it does not
+func todoTypeExists(w http.ResponseWriter, r *http.Request, name string) bool {
+	store := r.Context().Value("store").(*storage.Store)
+	_, err := store.TodoTypes.Find(r.Context(), name)
+	if err == storage.ErrNotFound {
+		http.Error(w, "Unknown todo type: "+name, http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch todo type")
+		return false
+	}
+	return true
+}
+
+// todoTypeFree checks that no todo type has the given name and writes a 409
+// response if one does
+func todoTypeFree(w http.ResponseWriter, r *http.Request, name, failed string) bool {
+	store := r.Context().Value("store").(*storage.Store)
+	_, err := store.TodoTypes.Find(r.Context(), name)
+	if err == nil {
+		http.Error(w, "Todo type already exists", http.StatusConflict)
+		return false
+	}
+	if err != storage.ErrNotFound {
+		serverError(w, r, err, failed)
+		return false
+	}
+	return true
+}
+
+// findTodoType loads the named todo type and writes a 404 response if it
+// does not exist
+func findTodoType(w http.ResponseWriter, r *http.Request, name string) (*models.TodoType, bool) {
+	store := r.Context().Value("store").(*storage.Store)
+	todoType, err := store.TodoTypes.Find(r.Context(), name)
+	if err == storage.ErrNotFound {
+		http.Error(w, "Todo type not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch todo type")
+		return nil, false
+	}
+	return todoType, true
+}
@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/middleware"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// TodoRequest represents the request body for creating or updating a todo
+type TodoRequest struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// TodoBoard is the whole todo board: the main list and one column per type
+type TodoBoard struct {
+	Main    []models.Todo `json:"main"`
+	Columns []TodoColumn  `json:"columns"`
+}
+
+// TodoColumn holds the todos moved into the column of a type
+type TodoColumn struct {
+	Type  string        `json:"type"`
+	Todos []models.Todo `json:"todos"`
+}
+
+// GetTodos responds with a page of todos. The list can be filtered with q,
+// type and state and ordered with sort, and supports the same offset and
+// cursor pagination as GetItems.
+func GetTodos(w http.ResponseWriter, r *http.Request) {
+	todos := []models.Todo{}
+
+	// Get query parameters
+	filter, err := parseTodoFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Count the matching todos before paginating
+	store := r.Context().Value("store").(*storage.Store)
+	total, err := store.Todos.Count(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch todos")
+		return
+	}
+
+	response := ListResponse{Total: total, Limit: filter.Limit}
+
+	if usesCursor(r.URL.Query()) {
+		// Apply keyset pagination
+		cfg := r.Context().Value("config").(*config.Config)
+		fetch := func(order []models.SortField, after []interface{}, limit int) ([]models.Todo, error) {
+			page := filter
+			page.Sort, page.After, page.Limit, page.Offset = order, after, limit, 0
+			return store.Todos.List(r.Context(), page)
+		}
+		page, cursors, err := paginateCursor(filter.Sort, r.URL.Query().Get("cursor"), filter.Limit, cfg.CursorSecret.Value(), fetch, models.TodoSortValue)
+		if err == errInvalidCursor {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err, "Failed to fetch todos")
+			return
+		}
+		todos = page
+		response.NextCursor = cursors.NextCursor
+		response.PrevCursor = cursors.PrevCursor
+		setLinkHeader(w, r, cursorLinks(cursors))
+	} else {
+		// Apply sorting and offset pagination
+		if todos, err = store.Todos.List(r.Context(), filter); err != nil {
+			serverError(w, r, err, "Failed to fetch todos")
+			return
+		}
+		response.Offset = &filter.Offset
+		setLinkHeader(w, r, offsetLinks(filter.Limit, filter.Offset, total))
+	}
+	response.Items = todos
+
+	// Return the todos
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetTodoBoard responds with every todo, split into the main list and the
+// columns of the todo types in their configured order. Todos appear in the
+// order they arrived in their list.
+func GetTodoBoard(w http.ResponseWriter, r *http.Request) {
+	// Find the columns
+	store := r.Context().Value("store").(*storage.Store)
+	types, err := store.TodoTypes.List(r.Context())
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch todo types")
+		return
+	}
+
+	// Find the todos in the main list
+	board := TodoBoard{Columns: []TodoColumn{}}
+	if board.Main, err = store.Todos.ListState(r.Context(), models.TodoStateMain); err != nil {
+		serverError(w, r, err, "Failed to fetch todos")
+		return
+	}
+
+	// Find the moved todos and put them in their columns
+	moved, err := store.Todos.ListState(r.Context(), models.TodoStateMoved)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch todos")
+		return
+	}
+	columns := make(map[string][]models.Todo, len(types))
+	for _, todo := range moved {
+		columns[todo.Type] = append(columns[todo.Type], todo)
+	}
+	for _, t := range types {
+		todos := columns[t.Name]
+		if todos == nil {
+			todos = []models.Todo{}
+		}
+		board.Columns = append(board.Columns, TodoColumn{Type: t.Name, Todos: todos})
+	}
+
+	// Return the board
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(board)
+}
+
+// GetTodoByID returns a todo by ID
+func GetTodoByID(w http.ResponseWriter, r *http.Request) {
+	// Find the todo in the store
+	todo, ok := findTodo(w, r)
+	if !ok {
+		return
+	}
+
+	// Skip the body if the client already has this version
+	if notModified(w, r, etag(todo.Version)) {
+		return
+	}
+
+	// Return the todo
+	w.Header().Set("ETag", etag(todo.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(todo)
+}
+
+// CreateTodo adds a todo to the main list
+func CreateTodo(w http.ResponseWriter, r *http.Request) {
+	var req TodoRequest
+
+	// Parse request body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Name == "" || req.Type == "" {
+		http.Error(w, "Name and type are required", http.StatusBadRequest)
+		return
+	}
+	if !todoTypeExists(w, r, req.Type) {
+		return
+	}
+
+	// Get the claims from the context
+	claims, ok := r.Context().Value("user").(*middleware.Claims)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Save the todo to the store
+	store := r.Context().Value("store").(*storage.Store)
+	todo := models.Todo{
+		Name:        req.Name,
+		Type:        req.Type,
+		CreatedByID: claims.UserID,
+	}
+	if err := store.Todos.Create(r.Context(), &todo); err != nil {
+		serverError(w, r, err, "Failed to create todo")
+		return
+	}
+
+	// Return the created todo
+	w.Header().Set("ETag", etag(todo.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(todo)
+}
+
+// UpdateTodo changes the name and type of a todo. The type of a moved todo
+// cannot change, because that would move it to another column.
+func UpdateTodo(w http.ResponseWriter, r *http.Request) {
+	// Find the todo in the store
+	todo, ok := findTodo(w, r)
+	if !ok {
+		return
+	}
+
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(todo.Version)) {
+		return
+	}
+
+	// Parse request body
+	var req TodoRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Name == "" || req.Type == "" {
+		http.Error(w, "Name and type are required", http.StatusBadRequest)
+		return
+	}
+	if req.Type != todo.Type {
+		if todo.IsMoved() {
+			http.Error(w, "Move the todo back before changing its type", http.StatusConflict)
+			return
+		}
+		if !todoTypeExists(w, r, req.Type) {
+			return
+		}
+	}
+
+	// Save the updated todo unless it changed since it was read
+	store := r.Context().Value("store").(*storage.Store)
+	saved, err := store.Todos.Update(r.Context(), todo, map[string]interface{}{
+		"name": req.Name,
+		"type": req.Type,
+	})
+	if err != nil {
+		serverError(w, r, err, "Failed to update todo")
+		return
+	}
+	if !saved {
+		writeConflict(w, r)
+		return
+	}
+
+	// Return the updated todo
+	w.Header().Set("ETag", etag(todo.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(todo)
+}
+
+// DeleteTodo removes a todo from the board
+func DeleteTodo(w http.ResponseWriter, r *http.Request) {
+	// Find the todo in the store
+	todo, ok := findTodo(w, r)
+	if !ok {
+		return
+	}
+
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(todo.Version)) {
+		return
+	}
+
+	// Delete the todo unless it changed since it was read
+	store := r.Context().Value("store").(*storage.Store)
+	deleted, err := store.Todos.Delete(r.Context(), todo)
+	if err != nil {
+		serverError(w, r, err, "Failed to delete todo")
+		return
+	}
+	if !deleted {
+		writeConflict(w, r)
+		return
+	}
+
+	// Return success message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Todo deleted"})
+}
+
+// MoveTodo moves a todo from the main list into the column of its type
+func MoveTodo(w http.ResponseWriter, r *http.Request) {
+	transitionTodo(w, r, models.TodoActionMove, models.TodoStateMain, models.TodoStateMoved)
+}
+
+// MoveTodoBack moves a todo from its column back to the main list
+func MoveTodoBack(w http.ResponseWriter, r *http.Request) {
+	transitionTodo(w, r, models.TodoActionMoveBack, models.TodoStateMoved, models.TodoStateMain)
+}
+
+// GetTodoTransitions returns the moves of a todo, oldest first
+func GetTodoTransitions(w http.ResponseWriter, r *http.Request) {
+	// Find the todo in the store
+	todo, ok := findTodo(w, r)
+	if !ok {
+		return
+	}
+
+	// Find its transitions
+	store := r.Context().Value("store").(*storage.Store)
+	transitions, err := store.Todos.Transitions(r.Context(), todo.ID)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch transitions")
+		return
+	}
+
+	// Return the transitions
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(transitions)
+}
+
+// transitionTodo changes the state of a todo and records who changed it and
+// when. It responds with 409 if the todo is not in the from state.
+func transitionTodo(w http.ResponseWriter, r *http.Request, action, from, to string) {
+	// Get the claims from the context
+	claims, ok := r.Context().Value("user").(*middleware.Claims)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Find the todo in the store
+	todo, ok := findTodo(w, r)
+	if !ok {
+		return
+	}
+
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(todo.Version)) {
+		return
+	}
+
+	// Change the state
+	store := r.Context().Value("store").(*storage.Store)
+	if err := applyTodoTransition(r.Context(), store.Todos, claims, todo, action, from, to); err != nil {
+		writeError(w, r, err, "Failed to move todo")
+		return
+	}
+
+	// Return the moved todo
+	w.Header().Set("ETag", etag(todo.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(todo)
+}
+
+// applyTodoTransition changes the state of a todo unless it changed since it
+// was read, and records the transition and the change
+func applyTodoTransition(ctx context.Context, todos storage.TodoRepository, claims *middleware.Claims, todo *models.Todo, action, from, to string) error {
+	// Validate the transition
+	if todo.State != from {
+		return &statusError{http.StatusConflict, "Todo is not in the " + from + " state"}
+	}
+
+	// Change the state unless the todo changed since it was read
+	now := time.Now()
+	changes := map[string]interface{}{"state": to, "moved_at": nil, "moved_by_id": nil}
+	if to == models.TodoStateMoved {
+		changes["moved_at"] = now
+		changes["moved_by_id"] = claims.UserID
+	}
+
+	// Record the transition along with the change
+	transition := models.TodoTransition{
+		TodoID:    todo.ID,
+		Action:    action,
+		FromState: from,
+		ToState:   to,
+		Type:      todo.Type,
+		UserID:    claims.UserID,
+		CreatedAt: now,
+	}
+	saved, err := todos.Transition(ctx, todo, changes, &transition)
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return errModified
+	}
+	return nil
+}
+
+// findTodo loads the todo named in the URL and writes a 404 response if it
+// does not exist
+func findTodo(w http.ResponseWriter, r *http.Request) (*models.Todo, bool) {
+	store := r.Context().Value("store").(*storage.Store)
+	return findByID(w, r, store.Todos.Find, "Todo not found", "Failed to fetch todo")
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+func TestTodoMoves(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.TodoTypes.Seed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	id := map[string]string{"id": "1"}
+
+	// Create a todo in the main list
+	w := serve(CreateTodo, store, 1, "POST", "/api/todos", `{"name":"Apple","type":"Fruit"}`, nil)
+	expectStatus(t, w, http.StatusCreated)
+	w = serve(CreateTodo, store, 1, "POST", "/api/todos", `{"name":"Rock","type":"Mineral"}`, nil)
+	expectStatus(t, w, http.StatusBadRequest)
+
+	// Move it into its column, but only once
+	w = serve(MoveTodo, store, 1, "POST", "/api/todos/1/move", "", id)
+	expectStatus(t, w, http.StatusOK)
+	w = serve(MoveTodo, store, 1, "POST", "/api/todos/1/move", "", id)
+	expectStatus(t, w, http.StatusConflict)
+
+	w = serve(GetTodoBoard, store, 1, "GET", "/api/todos/board", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	var board TodoBoard
+	if err := json.NewDecoder(w.Body).Decode(&board); err != nil {
+		t.Fatal(err)
+	}
+	if len(board.Main) != 0 || len(board.Columns) != 2 || len(board.Columns[0].Todos) != 1 {
+		t.Fatalf("board = %+v, want the todo in the Fruit column", board)
+	}
+
+	// Move it back, and find both moves recorded
+	w = serve(MoveTodoBack, store, 1, "POST", "/api/todos/1/move-back", "", id)
+	expectStatus(t, w, http.StatusOK)
+	var todo models.Todo
+	if err := json.NewDecoder(w.Body).Decode(&todo); err != nil {
+		t.Fatal(err)
+	}
+	if todo.State != models.TodoStateMain || todo.MovedAt != nil || todo.Version != 3 {
+		t.Errorf("todo moved back = %+v, want it in the main list at version 3", todo)
+	}
+
+	w = serve(GetTodoTransitions, store, 1, "GET", "/api/todos/1/transitions", "", id)
+	expectStatus(t, w, http.StatusOK)
+	var transitions []models.TodoTransition
+	if err := json.NewDecoder(w.Body).Decode(&transitions); err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions) != 2 || transitions[0].Action != models.TodoActionMove || transitions[1].Action != models.TodoActionMoveBack {
+		t.Errorf("transitions = %+v, want the move and the move back", transitions)
+	}
+}
+
+func TestDeleteTodoTypeInUse(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.TodoTypes.Seed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	name := map[string]string{"name": "Fruit"}
+
+	w := serve(CreateTodo, store, 1, "POST", "/api/todos", `{"name":"Apple","type":"Fruit"}`, nil)
+	expectStatus(t, w, http.StatusCreated)
+	w = serve(DeleteTodoType, store, 1, "DELETE", "/api/todo-types/Fruit", "", name)
+	expectStatus(t, w, http.StatusConflict)
+
+	w = serve(DeleteTodo, store, 1, "DELETE", "/api/todos/1", "", map[string]string{"id": "1"})
+	expectStatus(t, w, http.StatusOK)
+	w = serve(DeleteTodoType, store, 1, "DELETE", "/api/todo-types/Fruit", "", name)
+	expectStatus(t, w, http.StatusOK)
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// defaultTrashSort lists the most recently deleted rows first
+var defaultTrashSort = []models.SortField{{Field: "deleted_at", Desc: true}, {Field: "id", Desc: true}}
+
+// GetItemTrash responds with a page of the caller's deleted items, most
+// recently deleted first. It accepts the same filters, sort fields and scope
+// parameters as GetItems, plus sorting by deleted_at, but only offset
+// pagination.
+func GetItemTrash(w http.ResponseWriter, r *http.Request) {
+	// Get query parameters
+	filter, err := parseItemFilter(r.URL.Query(), trashSortFields(models.ItemSortFields))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("sort") == "" {
+		filter.Sort = defaultTrashSort
+	}
+
+	// Scope the query to the caller's items unless an admin asks otherwise
+	if !scopeItemFilter(w, r, &filter) {
+		return
+	}
+
+	// List the deleted items only
+	filter.Deleted = true
+
+	// Count the matching items before paginating
+	store := r.Context().Value("store").(*storage.Store)
+	total, err := store.Items.Count(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch items")
+		return
+	}
+
+	// Fetch the page
+	items, err := store.Items.List(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch items")
+		return
+	}
+	setLinkHeader(w, r, offsetLinks(filter.Limit, filter.Offset, total))
+
+	// Return the items
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ListResponse{
+		Items:  items,
+		Total:  total,
+		Limit:  filter.Limit,
+		Offset: &filter.Offset,
+	})
+}
+
+// RestoreItem moves a deleted item out of the trash
+func RestoreItem(w http.ResponseWriter, r *http.Request) {
+	// Find the item in the trash
+	item, ok := findTrashedItem(w, r)
+	if !ok {
+		return
+	}
+
+	// Only the owner can restore the item unless the caller can manage all items
+	allowed, err := canAccessItem(r, item)
+	if err != nil {
+		serverError(w, r, err, "Failed to check permissions")
+		return
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(item.Version)) {
+		return
+	}
+
+	// Clear the deletion time unless the item changed since it was read
+	store := r.Context().Value("store").(*storage.Store)
+	restored, err := store.Items.Restore(r.Context(), item)
+	if err != nil {
+		serverError(w, r, err, "Failed to restore item")
+		return
+	}
+	if !restored {
+		writeConflict(w, r)
+		return
+	}
+
+	// Return the restored item
+	w.Header().Set("ETag", etag(item.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(item)
+}
+
+// PurgeItem permanently deletes an item from the trash (items:admin only)
+func PurgeItem(w http.ResponseWriter, r *http.Request) {
+	// Find the item in the trash
+	item, ok := findTrashedItem(w, r)
+	if !ok {
+		return
+	}
+
+	// Delete the item for good
+	store := r.Context().Value("store").(*storage.Store)
+	if err := store.Items.Purge(r.Context(), []uint{item.ID}); err != nil {
+		serverError(w, r, err, "Failed to purge item")
+		return
+	}
+
+	// Return success message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Item purged"})
+}
+
+// GetUserTrash returns a page of deleted users, most recently deleted first
+// (admin only)
+func GetUserTrash(w http.ResponseWriter, r *http.Request) {
+	// Get query parameters
+	limit, offset, err := parsePagination(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort, err := parseSort(r.URL.Query().Get("sort"), trashSortFields(models.UserSortFields))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("sort") == "" {
+		sort = defaultTrashSort
+	}
+
+	// List the deleted users only
+	filter := models.UserFilter{Deleted: true, Sort: sort, Limit: limit, Offset: offset}
+
+	// Count the deleted users before paginating
+	store := r.Context().Value("store").(*storage.Store)
+	total, err := store.Users.Count(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch users")
+		return
+	}
+
+	// Fetch the page
+	users, err := store.Users.List(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch users")
+		return
+	}
+	setLinkHeader(w, r, offsetLinks(limit, offset, total))
+
+	// Return the users
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ListResponse{
+		Items:  users,
+		Total:  total,
+		Limit:  limit,
+		Offset: &offset,
+	})
+}
+
+// RestoreUser moves a deleted user out of the trash (admin only). Tokens
+// revoked when the user was deleted stay revoked, so the user has to log in
+// again.
+func RestoreUser(w http.ResponseWriter, r *http.Request) {
+	// Find the user in the trash
+	user, ok := findTrashedUser(w, r)
+	if !ok {
+		return
+	}
+
+	// The client must have seen the current version
+	if !checkIfMatch(w, r, etag(user.Version)) {
+		return
+	}
+
+	// Clear the deletion time unless the user changed since it was read
+	store := r.Context().Value("store").(*storage.Store)
+	restored, err := store.Users.Restore(r.Context(), user)
+	if err != nil {
+		serverError(w, r, err, "Failed to restore user")
+		return
+	}
+	if !restored {
+		writeConflict(w, r)
+		return
+	}
+
+	// Return the restored user
+	w.Header().Set("ETag", etag(user.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// PurgeUser permanently deletes a user from the trash together with the
+// user's items and tokens (admin only)
+func PurgeUser(w http.ResponseWriter, r *http.Request) {
+	// Find the user in the trash
+	user, ok := findTrashedUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Delete the user and everything it owns for good
+	store := r.Context().Value("store").(*storage.Store)
+	if err := store.Users.Purge(r.Context(), []uint{user.ID}); err != nil {
+		serverError(w, r, err, "Failed to purge user")
+		return
+	}
+
+	// Return success message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "User purged"})
+}
+
+// trashSortFields adds deleted_at to the sort fields of a model
+func trashSortFields(fields []string) []string {
+	return append([]string{"deleted_at"}, fields...)
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/niphawanphoopha/go-web-api/events"
+	"github.com/niphawanphoopha/go-web-api/middleware"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+	"github.com/niphawanphoopha/go-web-api/webhooks"
+)
+
+// WebhookRequest represents the request body for creating or updating a
+// webhook
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"` // Defaults to true, or unchanged on update
+	Secret string   `json:"secret"` // Generated if empty on create, rotated if set on update
+}
+
+// deliveryStatuses are the values accepted by the status filter of the
+// delivery log
+var deliveryStatuses = []string{models.DeliveryPending, models.DeliverySucceeded, models.DeliveryFailed}
+
+// GetWebhooks returns all webhooks, without their secrets
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	store := r.Context().Value("store").(*storage.Store)
+	hooks, err := store.Webhooks.List(r.Context())
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch webhooks")
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// GetWebhookByID returns a webhook, without its secret
+func GetWebhookByID(w http.ResponseWriter, r *http.Request) {
+	hook, ok := findWebhook(w, r)
+	if !ok {
+		return
+	}
+	hook.Secret = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// CreateWebhook registers a webhook. The response is the only one that
+// includes the signing secret.
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+
+	// Parse request body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if err := validateWebhook(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get user from context
+	claims, ok := r.Context().Value("user").(*middleware.Claims)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Generate a secret unless the caller chose one
+	secret := req.Secret
+	if secret == "" {
+		var err error
+		if secret, err = webhooks.NewSecret(); err != nil {
+			serverError(w, r, err, "Failed to generate secret")
+			return
+		}
+	}
+
+	// Save the webhook to the store
+	store := r.Context().Value("store").(*storage.Store)
+	hook := models.Webhook{
+		URL:         req.URL,
+		Secret:      secret,
+		Events:      req.Events,
+		Active:      req.Active == nil || *req.Active,
+		CreatedByID: claims.UserID,
+	}
+	if err := store.Webhooks.Create(r.Context(), &hook); err != nil {
+		serverError(w, r, err, "Failed to create webhook")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// UpdateWebhook replaces the URL and event filters of a webhook, and its
+// active flag and secret if given. A new secret is returned once.
+func UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request body
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if err := validateWebhook(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Save the changes
+	hook.URL = req.URL
+	hook.Events = req.Events
+	if req.Active != nil {
+		hook.Active = *req.Active
+	}
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+	store := r.Context().Value("store").(*storage.Store)
+	if err := store.Webhooks.Update(r.Context(), hook); err != nil {
+		serverError(w, r, err, "Failed to update webhook")
+		return
+	}
+	if req.Secret == "" {
+		hook.Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// DeleteWebhook deletes a webhook along with its delivery log
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	// Delete the deliveries and the webhook together
+	store := r.Context().Value("store").(*storage.Store)
+	if err := store.Webhooks.Delete(r.Context(), hook); err != nil {
+		serverError(w, r, err, "Failed to delete webhook")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Webhook deleted"})
+}
+
+// GetWebhookDeliveries responds with a page of a webhook's delivery log, most
+// recent first, optionally filtered by status
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	// Get query parameters
+	limit, offset, err := parsePagination(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := models.DeliveryFilter{WebhookID: hook.ID, Status: r.URL.Query().Get("status"), Limit: limit, Offset: offset}
+	if filter.Status != "" && !contains(deliveryStatuses, filter.Status) {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	// Count the matching deliveries before paginating
+	store := r.Context().Value("store").(*storage.Store)
+	total, err := store.Webhooks.CountDeliveries(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch deliveries")
+		return
+	}
+
+	// Fetch the page
+	deliveries, err := store.Webhooks.ListDeliveries(r.Context(), filter)
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch deliveries")
+		return
+	}
+	setLinkHeader(w, r, offsetLinks(limit, offset, total))
+
+	// Return the deliveries
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ListResponse{
+		Items:  deliveries,
+		Total:  total,
+		Limit:  limit,
+		Offset: &offset,
+	})
+}
+
+// RedeliverWebhook queues the event of a past delivery to be sent again. The
+// new delivery keeps the event ID, so receivers can tell it is a repeat.
+func RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	// Find the delivery in the webhook's log
+	store := r.Context().Value("store").(*storage.Store)
+	id, err := strconv.ParseUint(mux.Vars(r)["delivery"], 10, 64)
+	if err != nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	delivery, err := store.Webhooks.FindDelivery(r.Context(), hook.ID, uint(id))
+	if err == storage.ErrNotFound {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err, "Failed to fetch delivery")
+		return
+	}
+
+	// Queue the new delivery
+	redelivery, err := webhooks.Redeliver(r.Context(), store.Webhooks, delivery)
+	if err != nil {
+		serverError(w, r, err, "Failed to redeliver event")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(redelivery)
+}
+
+// validateWebhook checks the URL and event filters of a webhook request
+func validateWebhook(req WebhookRequest) error {
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if len(req.Events) == 0 {
+		return errors.New("events must list at least one event type")
+	}
+	for _, filter := range req.Events {
+		if !validEventFilter(filter) {
+			return errors.New("unknown event type: " + filter)
+		}
+	}
+	return nil
+}
+
+// validEventFilter checks that an event filter matches at least one event type
+func validEventFilter(filter string) bool {
+	for _, eventType := range events.Types {
+		if events.Match(filter, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// findWebhook loads the webhook named in the URL and writes a 404 response if
+// it does not exist
+func findWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	store := r.Context().Value("store").(*storage.Store)
+	return findByID(w, r, store.Webhooks.Find, "Webhook not found", "Failed to fetch webhook")
+}
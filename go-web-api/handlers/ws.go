@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/events"
+	"github.com/niphawanphoopha/go-web-api/middleware"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+const (
+	// wsMaxMessageSize is the largest message a client may send
+	wsMaxMessageSize = 4096
+	// wsWriteWait is how long a write to a client may take
+	wsWriteWait = 10 * time.Second
+)
+
+// wsUpgrader accepts connections from any origin: clients send the token
+// themselves rather than relying on cookies, so other sites cannot act for a
+// user
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// wsTopicPermissions are the topics clients can subscribe to and the
+// permissions needed to follow and change them
+var wsTopicPermissions = map[string]struct{ read, write string }{
+	events.TopicItems: {models.PermItemsRead, models.PermItemsWrite},
+	events.TopicTodos: {models.PermTodosRead, models.PermTodosWrite},
+}
+
+// WSRequest is a message from a WebSocket client
+type WSRequest struct {
+	Type    string  `json:"type"`    // subscribe, unsubscribe, move or move_back
+	Ref     string  `json:"ref"`     // Echoed in the reply
+	Topic   string  `json:"topic"`   // items or todos
+	Since   *uint64 `json:"since"`   // With subscribe, the ID of the last event received
+	ID      uint    `json:"id"`      // With move and move_back, the item or todo to move
+	Version *uint   `json:"version"` // With move and move_back, the version the client has seen
+	TTL     *int    `json:"ttl"`     // With move on items, seconds until the item returns
+}
+
+// WSMessage is a message to a WebSocket client
+type WSMessage struct {
+	Type   string        `json:"type"` // event, reset, subscribed, unsubscribed, result or error
+	Ref    string        `json:"ref,omitempty"`
+	Topic  string        `json:"topic,omitempty"`
+	Event  *events.Event `json:"event,omitempty"`
+	Data   interface{}   `json:"data,omitempty"`
+	Status int           `json:"status,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// wsClient is an open WebSocket connection
+type wsClient struct {
+	conn   *websocket.Conn
+	claims *middleware.Claims
+	cfg    *config.Config
+	store  *storage.Store
+
+	mu       sync.Mutex        // guards writes to conn and lastSent
+	lastSent map[string]uint64 // ID of the last event sent per subscribed topic
+}
+
+// ServeWS upgrades the request to a WebSocket over which clients subscribe to
+// topics, receive the changes they can see in the order they were made, and
+// move items and todos. The connection closes when the token expires or is
+// revoked.
+func ServeWS(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("config").(*config.Config)
+
+	// Get user from context
+	claims, ok := r.Context().Value("user").(*middleware.Claims)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Upgrade the connection; Upgrade responds to the client on failure
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	store := r.Context().Value("store").(*storage.Store)
+	client := &wsClient{conn: conn, claims: claims, cfg: cfg, store: store, lastSent: make(map[string]uint64)}
+
+	// Subscribe before reading requests so no event falls in between
+	sub := events.Default.Subscribe()
+	defer events.Default.Unsubscribe(sub)
+
+	// Handle requests until the client goes away
+	heartbeat := time.Duration(cfg.EventHeartbeat) * time.Second
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.readRequests(2 * heartbeat)
+	}()
+
+	ping := time.NewTicker(heartbeat)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case event, open := <-sub.C:
+			// The subscriber fell behind or the server is shutting down; the
+			// client reconnects and resubscribes with since
+			if !open {
+				client.close(websocket.CloseTryAgainLater, "event stream closed")
+				return
+			}
+			if err := client.sendEvent(event); err != nil {
+				return
+			}
+
+		case <-ping.C:
+			// Close the connection once the token stops being valid
+			if claims.ExpiresAt != 0 && time.Now().Unix() > claims.ExpiresAt {
+				client.close(websocket.ClosePolicyViolation, "token expired")
+				return
+			}
+			if revoked, err := middleware.Revocations.IsRevoked(claims); err != nil || revoked {
+				client.close(websocket.ClosePolicyViolation, "token revoked")
+				return
+			}
+			client.mu.Lock()
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			client.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readRequests handles requests from the client until the connection fails
+// or no pong arrives within pongWait
+func (c *wsClient) readRequests(pongWait time.Duration) {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req WSRequest
+		if err := json.Unmarshal(message, &req); err != nil {
+			c.send(WSMessage{Type: "error", Status: http.StatusBadRequest, Error: "Invalid request body"})
+			continue
+		}
+		if err := c.handle(req); err != nil {
+			status, message := errorStatus(err, "Failed to handle request")
+			c.send(WSMessage{Type: "error", Ref: req.Ref, Topic: req.Topic, Status: status, Error: message})
+		}
+	}
+}
+
+// handle runs a client request and sends the reply
+func (c *wsClient) handle(req WSRequest) error {
+	// Validate the topic and check the caller can use it
+	permissions, ok := wsTopicPermissions[req.Topic]
+	if !ok {
+		return &statusError{http.StatusBadRequest, "Unknown topic"}
+	}
+	permission := permissions.read
+	if req.Type == "move" || req.Type == "move_back" {
+		permission = permissions.write
+	}
+	allowed, err := middleware.HasPermission(c.claims, permission)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &statusError{http.StatusForbidden, "Forbidden"}
+	}
+
+	switch req.Type {
+	case "subscribe":
+		return c.subscribe(req)
+	case "unsubscribe":
+		c.mu.Lock()
+		delete(c.lastSent, req.Topic)
+		c.mu.Unlock()
+		return c.send(WSMessage{Type: "unsubscribed", Ref: req.Ref, Topic: req.Topic})
+	case "move", "move_back":
+		resource, err := c.move(req)
+		if err != nil {
+			return err
+		}
+		return c.send(WSMessage{Type: "result", Ref: req.Ref, Topic: req.Topic, Data: resource})
+	}
+	return &statusError{http.StatusBadRequest, "Unknown request type"}
+}
+
+// subscribe starts sending the events of a topic. With since, the logged
+// events after that ID are sent first, or a reset if they are gone.
+func (c *wsClient) subscribe(req WSRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.write(WSMessage{Type: "subscribed", Ref: req.Ref, Topic: req.Topic}); err != nil {
+		return err
+	}
+	if req.Since == nil {
+		c.lastSent[req.Topic] = events.Default.LastID()
+		return nil
+	}
+
+	// Replay the events the client missed
+	c.lastSent[req.Topic] = *req.Since
+	missed, ok := events.Default.Since(*req.Since)
+	if !ok {
+		c.lastSent[req.Topic] = events.Default.LastID()
+		return c.write(WSMessage{Type: "reset", Ref: req.Ref, Topic: req.Topic})
+	}
+	for _, event := range missed {
+		if event.Topic != req.Topic {
+			continue
+		}
+		if err := c.writeEvent(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move moves an item or todo, or moves it back, and returns it
+func (c *wsClient) move(req WSRequest) (interface{}, error) {
+	if req.Topic == events.TopicTodos {
+		todo, err := c.store.Todos.Find(context.Background(), req.ID)
+		if err == storage.ErrNotFound {
+			return nil, &statusError{http.StatusNotFound, "Todo not found"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if req.Version != nil && *req.Version != todo.Version {
+			return nil, &statusError{http.StatusPreconditionFailed, "Resource has been modified"}
+		}
+		if req.Type == "move" {
+			err = applyTodoTransition(context.Background(), c.store.Todos, c.claims, todo, models.TodoActionMove, models.TodoStateMain, models.TodoStateMoved)
+		} else {
+			err = applyTodoTransition(context.Background(), c.store.Todos, c.claims, todo, models.TodoActionMoveBack, models.TodoStateMoved, models.TodoStateMain)
+		}
+		return todo, err
+	}
+
+	// Only the owner can move the item unless the caller can manage all items
+	ctx := context.Background()
+	item, err := c.store.Items.Find(ctx, req.ID)
+	if err == storage.ErrNotFound {
+		return nil, &statusError{http.StatusNotFound, "Item not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := claimsCanAccessItem(c.claims, item)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, &statusError{http.StatusForbidden, "Forbidden"}
+	}
+	if req.Version != nil && *req.Version != item.Version {
+		return nil, &statusError{http.StatusPreconditionFailed, "Resource has been modified"}
+	}
+	if req.Type == "move_back" {
+		return item, moveItemBack(ctx, c.store.Items, item)
+	}
+	ttl := c.cfg.ItemMoveTTL
+	if req.TTL != nil {
+		ttl = *req.TTL
+	}
+	return item, moveItem(ctx, c.store.Items, item, ttl)
+}
+
+// sendEvent sends an event if the client follows its topic, has not been
+// sent it yet and can see it
+func (c *wsClient) sendEvent(event events.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lastID, subscribed := c.lastSent[event.Topic]
+	if !subscribed || event.ID <= lastID {
+		return nil
+	}
+	return c.writeEvent(event)
+}
+
+// writeEvent writes an event if the client can see it. The caller must hold mu.
+func (c *wsClient) writeEvent(event events.Event) error {
+	c.lastSent[event.Topic] = event.ID
+	visible, err := eventVisible(c.claims, event)
+	if err != nil || !visible {
+		return err
+	}
+	return c.write(WSMessage{Type: "event", Topic: event.Topic, Event: &event})
+}
+
+// send writes a message to the client
+func (c *wsClient) send(message WSMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(message)
+}
+
+// write writes a message to the client. The caller must hold mu.
+func (c *wsClient) write(message WSMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(message)
+}
+
+// close tells the client why the connection is closing
+func (c *wsClient) close(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+}
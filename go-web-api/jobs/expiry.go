@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// StartItemExpiry returns moved items and trashes expired items every expiry
+// interval until the context is cancelled
+func StartItemExpiry(ctx context.Context, cfg *config.Config, store *storage.Store) {
+	if cfg.ItemExpiryInterval <= 0 {
+		log.Println("Item expiry disabled")
+		return
+	}
+
+	interval := time.Duration(cfg.ItemExpiryInterval) * time.Second
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				expireItems(ctx, store.Items, now)
+			}
+		}
+	}()
+}
+
+// expireItems runs a single expiry pass and logs the outcome
+func expireItems(ctx context.Context, items storage.ItemRepository, now time.Time) {
+	returned, expired, err := items.Expire(ctx, now)
+	if err != nil {
+		log.Printf("Failed to expire items: %v", err)
+		return
+	}
+	if len(returned) > 0 || len(expired) > 0 {
+		log.Printf("Returned %d moved items and expired %d items", len(returned), len(expired))
+	}
+}
@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/events"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// StartOutboxRelay publishes the events recorded in the outbox as soon as
+// their transaction commits, and every outbox interval to pick up events
+// left behind by a crash or a failed publisher, until the context is
+// cancelled. It also streams the committed events to the clients of this
+// instance, including those recorded by other instances, which are picked up
+// every outbox interval. Published events are purged once older than the
+// retention. The outbox is kept by the database store only, so the relay
+// runs against the database.
+func StartOutboxRelay(ctx context.Context, cfg *config.Config, db *gorm.DB) {
+	interval := time.Duration(cfg.OutboxInterval) * time.Second
+	if interval <= 0 {
+		log.Println("Outbox polling disabled, relaying on commit only")
+	}
+
+	go func() {
+		tail := events.NewTail(time.Now())
+
+		// Relay what was left in the outbox before the last shutdown
+		relayOutbox(db)
+
+		var poll <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			poll = ticker.C
+		}
+		purge := time.NewTicker(time.Hour)
+		defer purge.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-events.Committed():
+				relayOutbox(db)
+				streamOutbox(db, tail)
+			case <-poll:
+				relayOutbox(db)
+				streamOutbox(db, tail)
+			case now := <-purge.C:
+				purgeOutbox(db, cfg, now)
+			}
+		}
+	}()
+}
+
+// relayOutbox publishes pending outbox events until none are left or a
+// publisher fails, and logs failures
+func relayOutbox(db *gorm.DB) {
+	for {
+		relayed, err := events.Relay(db, time.Now())
+		if err != nil {
+			log.Printf("Failed to relay outbox events: %v", err)
+			return
+		}
+		if relayed == 0 {
+			return
+		}
+	}
+}
+
+// streamOutbox streams the newly committed outbox events to the clients of
+// this instance, and logs failures
+func streamOutbox(db *gorm.DB, tail *events.Tail) {
+	if _, err := tail.Stream(db, time.Now()); err != nil {
+		log.Printf("Failed to stream outbox events: %v", err)
+	}
+}
+
+// purgeOutbox deletes the published events older than the retention and logs
+// the outcome
+func purgeOutbox(db *gorm.DB, cfg *config.Config, now time.Time) {
+	if cfg.OutboxRetention <= 0 {
+		return
+	}
+	cutoff := now.Add(-time.Duration(cfg.OutboxRetention) * time.Hour)
+	purged, err := models.PurgeOutbox(db, cutoff)
+	if err != nil {
+		log.Printf("Failed to purge outbox: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d published outbox events", purged)
+	}
+}
@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+)
+
+// StartSecretRefresh re-reads the secrets loaded from files and the secret
+// provider every refresh interval, until the context is cancelled, so
+// rotated secrets are picked up without a restart. It does nothing if the
+// interval is 0.
+func StartSecretRefresh(ctx context.Context, cfg *config.Config) {
+	if cfg.SecretsRefreshInterval <= 0 {
+		log.Println("Secret refresh disabled")
+		return
+	}
+
+	interval := time.Duration(cfg.SecretsRefreshInterval) * time.Second
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			refreshSecrets(cfg)
+		}
+	}()
+}
+
+// refreshSecrets re-reads the secrets once and logs which ones changed, but
+// never their values
+func refreshSecrets(cfg *config.Config) {
+	changed, err := cfg.RefreshSecrets()
+	if err != nil {
+		log.Printf("Failed to refresh secrets: %v", err)
+	}
+	if len(changed) > 0 {
+		log.Printf("Rotated secrets: %s", strings.Join(changed, ", "))
+	}
+}
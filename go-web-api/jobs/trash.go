@@ -0,0 +1,57 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// StartTrashPurger purges items and users that have been in the trash longer
+// than the retention window, once at startup and then every purge interval,
+// until the context is cancelled. It does nothing if retention is disabled.
+func StartTrashPurger(ctx context.Context, cfg *config.Config, store *storage.Store) {
+	if cfg.TrashRetentionDays <= 0 || cfg.TrashPurgeInterval <= 0 {
+		log.Println("Trash purger disabled")
+		return
+	}
+
+	retention := time.Duration(cfg.TrashRetentionDays) * 24 * time.Hour
+	interval := time.Duration(cfg.TrashPurgeInterval) * time.Minute
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purgeTrash(ctx, store, retention)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// purgeTrash runs a single purge and logs the outcome. Items go first, so
+// the count does not include the items of purged users.
+func purgeTrash(ctx context.Context, store *storage.Store, retention time.Duration) {
+	cutoff := time.Now().Add(-retention)
+	items, err := store.Items.PurgeTrash(ctx, cutoff)
+	if err != nil {
+		log.Printf("Failed to purge trash: %v", err)
+		return
+	}
+	users, err := store.Users.PurgeTrash(ctx, cutoff)
+	if err != nil {
+		log.Printf("Failed to purge trash: %v", err)
+		return
+	}
+	if items > 0 || users > 0 {
+		log.Printf("Purged %d items and %d users from the trash", items, users)
+	}
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/storage"
+	"github.com/niphawanphoopha/go-web-api/webhooks"
+)
+
+// StartWebhookDelivery sends the due webhook deliveries of the store every
+// webhook interval until the context is cancelled
+func StartWebhookDelivery(ctx context.Context, cfg *config.Config, store *storage.Store) {
+	if cfg.WebhookInterval <= 0 {
+		log.Println("Webhook delivery disabled")
+		return
+	}
+
+	dispatcher := webhooks.NewDispatcher(cfg, store.Webhooks)
+	interval := time.Duration(cfg.WebhookInterval) * time.Second
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				deliverWebhooks(dispatcher, now)
+			}
+		}
+	}()
+}
+
+// deliverWebhooks runs a single delivery pass and logs failures
+func deliverWebhooks(dispatcher *webhooks.Dispatcher, now time.Time) {
+	if _, err := dispatcher.DeliverDue(now); err != nil {
+		log.Printf("Failed to deliver webhooks: %v", err)
+	}
+}
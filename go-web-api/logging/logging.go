@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+)
+
+// level is the lowest level logged, changed on configuration reloads
+var level slog.LevelVar
+
+// Setup makes a logger writing LOG_FORMAT lines to w the default logger, for
+// both slog and the log package, whose lines are logged at info level
+func Setup(w io.Writer, cfg *config.Config) {
+	Configure(cfg)
+
+	options := &slog.HandlerOptions{Level: &level}
+	var handler slog.Handler
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// Configure applies LOG_LEVEL, or the debug level in debug mode, at startup
+// and when the configuration is reloaded
+func Configure(cfg *config.Config) {
+	if cfg.Debug {
+		level.Set(slog.LevelDebug)
+		return
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		l = slog.LevelInfo
+	}
+	level.Set(l)
+}
+
+// WithLogger returns a context holding a logger, such as one carrying the
+// request ID
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, "logger", logger)
+}
+
+// FromContext returns the logger of a context, or the default logger outside
+// of a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value("logger").(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
@@ -2,19 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
-
+	
 	"github.com/gin-gonic/gin"
-	"github.com/niphawanphoopha/go-web-api/api"
 	"github.com/niphawanphoopha/go-web-api/config"
 	"github.com/niphawanphoopha/go-web-api/database"
-	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/logging"
+	"github.com/niphawanphoopha/go-web-api/storage"
 )
 
 // Item represents data about a record Item.
@@ -32,55 +31,115 @@ var items = []Item{
 	{ID: "3", Title: "Item 3", Description: "This is item 3", Price: 39.99},
 }
 
+// usage describes the commands of the binary
+const usage = `Usage: go-web-api [flags] [command]
+
+Flags override any setting, named after its environment variable, e.g.
+-port 8080 for PORT, and -config names a YAML or TOML config file. They
+come before the command, which all share the same configuration.
+
+Commands:
+  serve     Start the API server (the default)
+  migrate   Apply, roll back, list and create database migrations
+  seed      Seed the built-in roles, todo columns and fixture items
+  user      Create users and reset their passwords
+  config    Print the configuration
+  vault     Manage the encrypted secrets vault
+`
+
+// commands maps each command to the function running it, which returns the
+// exit code
+var commands = map[string]func(cfg *config.Config, args []string) int{
+	"serve":   runServe,
+	"migrate": runMigrate,
+	"seed":    runSeed,
+	"user":    runUser,
+	"config":  runConfig,
+	"vault":   runVault,
+}
+
 func main() {
-	// Load configuration
-	cfg := config.New()
-	
-	// Initialize database
-	if err := database.Init(cfg); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	// Load configuration from the config file, environment and flags
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Print(usage)
+		return
 	}
-	defer database.Close()
-	
-	// Auto-migrate models
-	if err := database.AutoMigrate(&models.User{}, &models.Item{}); err != nil {
-		log.Fatalf("Failed to migrate database: %v", err)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
+	config.Set(cfg)
+	
+	// Log in LOG_FORMAT, keeping secrets out of the logs
+	logging.Setup(config.NewRedactor(os.Stderr, cfg), cfg)
 	
-	// Create a new server
-	router := api.SetupRoutes(cfg)
-	server := &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
-		Handler:      router,
-		ReadTimeout:  time.Duration(cfg.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
+	// Run the command, the server by default
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Print(usage)
+		return
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+	os.Exit(run(cfg, args))
+}
+
+// openStore connects to the database and opens the store for the commands
+// that change data, after seeding the built-in roles and todo columns like
+// the server does. The memory store would be lost when the command exits,
+// so STORAGE must be database. The caller closes the database.
+func openStore(cfg *config.Config) (*storage.Store, error) {
+	if cfg.Storage != "database" {
+		return nil, fmt.Errorf("STORAGE is %s, but the command needs the database", cfg.Storage)
+	}
+	if err := database.Init(cfg); err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %v", err)
 	}
 	
-	// Start the server in a goroutine
-	go func() {
-		log.Printf("Server starting on %s:%d...\n", cfg.Host, cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+	store, err := func() (*storage.Store, error) {
+		if err := database.CheckSchema(context.Background()); err != nil {
+			return nil, err
+		}
+		store, err := storage.New(cfg, database.DB)
+		if err != nil {
+			return nil, err
 		}
+		if err := store.Roles.Seed(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to seed roles: %v", err)
+		}
+		if err := store.TodoTypes.Seed(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to seed todo types: %v", err)
+		}
+		return store, nil
 	}()
-	
-	// Wait for interrupt signal to gracefully shut down the server
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	
-	log.Println("Shutting down server...")
-	
-	// Create a deadline for server shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	
-	// Shutdown the server
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// parseArgs parses the flags of a command wherever they appear among its
+// arguments, so both "create --admin bob" and "create bob --admin" work, and
+// returns the other arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return rest, nil
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
 	}
-	
-	log.Println("Server exiting")
 }
 
 func setupRoutes(router *gin.Engine) {
@@ -90,7 +149,7 @@ func setupRoutes(router *gin.Engine) {
 			"status": "ok",
 		})
 	})
-
+	
 	// API group
 	api := router.Group("/api")
 	{
@@ -112,7 +171,7 @@ func getItems(c *gin.Context) {
 // parameter sent by the client, then returns that item as a response.
 func getItemByID(c *gin.Context) {
 	id := c.Param("id")
-
+	
 	// Loop over the list of items, looking for
 	// an item whose ID matches the parameter.
 	for _, item := range items {
@@ -127,13 +186,13 @@ func getItemByID(c *gin.Context) {
 // createItem adds an item from JSON received in the request body.
 func createItem(c *gin.Context) {
 	var newItem Item
-
+	
 	// Call BindJSON to bind the received JSON to newItem.
 	if err := c.BindJSON(&newItem); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
+	
 	// Add the new item to the slice.
 	items = append(items, newItem)
 	c.JSON(http.StatusCreated, newItem)
@@ -143,12 +202,12 @@ func createItem(c *gin.Context) {
 func updateItem(c *gin.Context) {
 	id := c.Param("id")
 	var updatedItem Item
-
+	
 	if err := c.BindJSON(&updatedItem); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
+	
 	// Loop through the items, looking for an item with matching ID
 	for i, item := range items {
 		if item.ID == id {
@@ -164,7 +223,7 @@ func updateItem(c *gin.Context) {
 // deleteItem removes an item from items slice.
 func deleteItem(c *gin.Context) {
 	id := c.Param("id")
-
+	
 	// Loop through the items, looking for an item with matching ID
 	for i, item := range items {
 		if item.ID == id {
@@ -175,4 +234,4 @@ func deleteItem(c *gin.Context) {
 		}
 	}
 	c.JSON(http.StatusNotFound, gin.H{"message": "item not found"})
-} 
\ No newline at end of file
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registry holds the API's metrics, along with the Go runtime and process
+// metrics
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+var (
+	// RequestsTotal counts the requests served, by method, route template
+	// and status code
+	RequestsTotal = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests served, by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	// RequestDuration observes how long requests take, by method and route
+	// template
+	RequestDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency in seconds, by method and route template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// RequestsInFlight is the number of requests being served, including
+	// open event streams and WebSocket connections
+	RequestsInFlight = promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+
+	// LoginsTotal counts login attempts by result, "success" or "failure"
+	LoginsTotal = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_logins_total",
+		Help: "Login attempts, by result.",
+	}, []string{"result"})
+
+	// TokenFailuresTotal counts the access tokens rejected, by reason
+	TokenFailuresTotal = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_token_failures_total",
+		Help: "Access tokens rejected, by reason.",
+	}, []string{"reason"})
+)
+
+// RegisterDB exports the connection pool stats of a database, read from
+// db.Stats() on every scrape. Call it once the database is open.
+func RegisterDB(db *sql.DB, name string) error {
+	return registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
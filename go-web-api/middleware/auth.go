@@ -2,12 +2,17 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/logging"
+	"github.com/niphawanphoopha/go-web-api/metrics"
+	"github.com/niphawanphoopha/go-web-api/models"
 )
 
 // Claims represents the JWT claims
@@ -15,64 +20,126 @@ type Claims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// IssuedAtNano is the issue time in nanoseconds, as iat is in whole
+	// seconds and cannot tell a token issued right after its user's tokens
+	// were revoked from one issued right before
+	IssuedAtNano int64 `json:"iat_ns,omitempty"`
 	jwt.StandardClaims
 }
 
-// GenerateToken generates a new JWT token for a user
-func GenerateToken(userID uint, username, role string, cfg *config.Config) (string, error) {
+// GenerateToken generates a new JWT token for a user and returns it along
+// with its expiration time
+func GenerateToken(userID uint, username, role string, cfg *config.Config) (string, time.Time, error) {
 	// Set expiration time
-	expirationTime := time.Now().Add(time.Duration(cfg.JWTExpiry) * time.Minute)
+	issuedAt := time.Now()
+	expirationTime := issuedAt.Add(time.Duration(cfg.JWTExpiry) * time.Minute)
+	
+	// Generate a unique token ID so the token can be revoked
+	tokenID, err := models.NewTokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
 	
 	// Create claims
 	claims := &Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+		UserID:       userID,
+		Username:     username,
+		Role:         role,
+		IssuedAtNano: issuedAt.UnixNano(),
 		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
 			ExpiresAt: expirationTime.Unix(),
-			IssuedAt:  time.Now().Unix(),
+			IssuedAt:  issuedAt.Unix(),
 		},
 	}
 	
-	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	
-	// Sign token
-	tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+	// Sign with the active asymmetric key, or the shared secret if none are configured
+	var tokenString string
+	if signingKeys != nil {
+		token := jwt.NewWithClaims(signingKeys.active.Method, claims)
+		token.Header["kid"] = signingKeys.active.ID
+		tokenString, err = token.SignedString(signingKeys.active.Private)
+	} else {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err = token.SignedString([]byte(cfg.JWTSecret.Value()))
+	}
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 	
-	return tokenString, nil
+	return tokenString, expirationTime, nil
 }
 
-// AuthMiddleware is a middleware that checks for a valid JWT token
+// verificationKey returns a jwt.Keyfunc that selects the key to verify a
+// token with. Asymmetric tokens are matched by their kid header, and the
+// token's algorithm must match the key's so it cannot be swapped.
+func verificationKey(cfg *config.Config) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if signingKeys == nil {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+			}
+			return []byte(cfg.JWTSecret.Value()), nil
+		}
+		
+		kid, _ := token.Header["kid"].(string)
+		key, ok := signingKeys.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return key.Public, nil
+	}
+}
+
+// AuthMiddleware is a middleware that checks for a valid JWT token in the
+// Authorization header
 func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return authMiddleware(cfg, false)
+}
+
+// StreamAuthMiddleware is AuthMiddleware for the change streams, which also
+// takes the token from the access_token query parameter, as browsers cannot
+// set headers on EventSource and WebSocket requests. Query strings end up in
+// proxy logs and browser history, so no other route accepts it.
+func StreamAuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return authMiddleware(cfg, true)
+}
+
+// authMiddleware checks for a valid JWT token, taken from the access_token
+// query parameter when queryToken is set and there is no Authorization header
+func authMiddleware(cfg *config.Config, queryToken bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Get the Authorization header
 			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
+			var tokenString string
+			if authHeader != "" {
+				// Check if the header has the Bearer prefix
+				parts := strings.Split(authHeader, " ")
+				if len(parts) != 2 || parts[0] != "Bearer" {
+					metrics.TokenFailuresTotal.WithLabelValues("bad_header").Inc()
+					http.Error(w, "Authorization header format must be Bearer {token}", http.StatusUnauthorized)
+					return
+				}
+				tokenString = parts[1]
+			} else if token := r.URL.Query().Get("access_token"); queryToken && token != "" {
+				tokenString = token
+			} else {
+				metrics.TokenFailuresTotal.WithLabelValues("missing").Inc()
 				http.Error(w, "Authorization header is required", http.StatusUnauthorized)
 				return
 			}
 			
-			// Check if the header has the Bearer prefix
-			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				http.Error(w, "Authorization header format must be Bearer {token}", http.StatusUnauthorized)
-				return
-			}
-			
 			// Parse the token
-			tokenString := parts[1]
 			claims := &Claims{}
 			
-			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(cfg.JWTSecret), nil
-			})
+			token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey(cfg))
 			
 			if err != nil {
+				metrics.TokenFailuresTotal.WithLabelValues(tokenFailureReason(err)).Inc()
 				if err == jwt.ErrSignatureInvalid {
 					http.Error(w, "Invalid token signature", http.StatusUnauthorized)
 					return
@@ -82,33 +149,49 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 			}
 			
 			if !token.Valid {
+				metrics.TokenFailuresTotal.WithLabelValues("invalid").Inc()
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
 			
-			// Add the claims to the request context
+			// Check if the token has been revoked
+			revoked, err := Revocations.IsRevoked(claims)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Failed to validate token", "error", err)
+				http.Error(w, "Failed to validate token", http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				metrics.TokenFailuresTotal.WithLabelValues("revoked").Inc()
+				http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+				return
+			}
+			
+			// Add the claims to the request context, and the user to its log
+			// lines
 			ctx := context.WithValue(r.Context(), "user", claims)
+			ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("user_id", claims.UserID))
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-// AdminMiddleware is a middleware that checks if the user has the admin role
-func AdminMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the claims from the context
-		claims, ok := r.Context().Value("user").(*Claims)
-		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		
-		// Check if the user has the admin role
-		if claims.Role != "admin" {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		
-		next.ServeHTTP(w, r)
-	})
-} 
\ No newline at end of file
+// tokenFailureReason names the reason a token failed to parse, for the token
+// failure metric
+func tokenFailureReason(err error) string {
+	var validationErr *jwt.ValidationError
+	if !errors.As(err, &validationErr) {
+		return "invalid"
+	}
+	switch {
+	case validationErr.Errors&jwt.ValidationErrorMalformed != 0:
+		return "malformed"
+	case validationErr.Errors&jwt.ValidationErrorUnverifiable != 0:
+		return "unverifiable"
+	case validationErr.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+		return "bad_signature"
+	case validationErr.Errors&jwt.ValidationErrorExpired != 0:
+		return "expired"
+	}
+	return "invalid"
+}
@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/niphawanphoopha/go-web-api/config"
+)
+
+// SigningKey is a key used to sign or verify JWTs
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{} // nil for verify-only keys
+	Public  crypto.PublicKey
+}
+
+// KeySet holds the active signing key and every key tokens may be verified with
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// JWK is a JSON Web Key as defined in RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// signingKeys is the key set used by GenerateToken and AuthMiddleware.
+// When nil, tokens are signed with HS256 and the configured JWT secret.
+var signingKeys *KeySet
+
+// InitKeys loads the asymmetric signing keys configured in cfg
+func InitKeys(cfg *config.Config) error {
+	if cfg.JWTKeysDir == "" {
+		signingKeys = nil
+		return nil
+	}
+
+	keySet, err := LoadKeySet(cfg.JWTKeysDir, cfg.JWTActiveKeyID)
+	if err != nil {
+		return err
+	}
+	signingKeys = keySet
+	return nil
+}
+
+// LoadKeySet loads every PEM file in dir as a key whose ID is the file name
+// without the .pem extension. Private keys (RSA or Ed25519) can sign and
+// verify; public keys only verify, which lets retired keys keep validating
+// the tokens they signed until those expire.
+func LoadKeySet(dir, activeID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keySet := &KeySet{keys: make(map[string]*SigningKey)}
+	var signers []*SigningKey
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %v", path, err)
+		}
+		keySet.keys[key.ID] = key
+		if key.Private != nil {
+			signers = append(signers, key)
+		}
+	}
+
+	// Pick the active key
+	switch {
+	case activeID != "":
+		key, ok := keySet.keys[activeID]
+		if !ok || key.Private == nil {
+			return nil, fmt.Errorf("active key %q has no private key in %s", activeID, dir)
+		}
+		keySet.active = key
+	case len(signers) == 1:
+		keySet.active = signers[0]
+	default:
+		return nil, fmt.Errorf("found %d private keys in %s, set the active key ID", len(signers), dir)
+	}
+
+	return keySet, nil
+}
+
+// Key returns the key with the given ID
+func (ks *KeySet) Key(id string) (*SigningKey, bool) {
+	key, ok := ks.keys[id]
+	return key, ok
+}
+
+// JWKS returns the public part of every key in the set
+func (ks *KeySet) JWKS() JWKSet {
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := JWKSet{Keys: make([]JWK, 0, len(ids))}
+	for _, id := range ids {
+		key := ks.keys[id]
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// PublicKeys returns the JWK set of the configured signing keys. It is empty
+// when tokens are signed with the shared HS256 secret.
+func PublicKeys() JWKSet {
+	if signingKeys == nil {
+		return JWKSet{Keys: []JWK{}}
+	}
+	return signingKeys.JWKS()
+}
+
+// loadKey parses a PEM encoded RSA or Ed25519 key
+func loadKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	key := &SigningKey{ID: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
@@ -1,14 +1,19 @@
 package middleware
 
 import (
-	"log"
+	"bufio"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/handlers"
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/logging"
 )
 
-// LoggingMiddleware logs the incoming HTTP request and response
+// LoggingMiddleware logs the incoming HTTP request and response, with the
+// request's logger
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -20,13 +25,12 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(rw, r)
 		
 		// Log the request details
-		log.Printf(
-			"%s %s %s %d %s",
-			r.RemoteAddr,
-			r.Method,
-			r.URL.Path,
-			rw.statusCode,
-			time.Since(start),
+		logging.FromContext(r.Context()).Info("request",
+			"remote_addr", r.RemoteAddr,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.statusCode,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
 		)
 	})
 }
@@ -43,13 +47,59 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// CorsMiddleware returns a middleware that handles CORS
+// Flush sends any buffered data to the client, for streaming responses
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, for WebSocket upgrades
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the underlying response writer so http.ResponseController
+// can reach it
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// CorsMiddleware returns a middleware that handles CORS for the origins in
+// CORS_ORIGINS. It follows configuration reloads, rebuilding the CORS
+// handler when the origins change.
 func CorsMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var mu sync.Mutex
+		var origins string
+		var handler http.Handler
+		
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := config.Current()
+			mu.Lock()
+			if handler == nil || origins != cfg.CORSOrigins {
+				origins = cfg.CORSOrigins
+				handler = cors(cfg.AllowedOrigins())(next)
+			}
+			h := handler
+			mu.Unlock()
+			
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// cors returns the CORS middleware for a list of allowed origins
+func cors(origins []string) func(http.Handler) http.Handler {
 	return handlers.CORS(
-		handlers.AllowedOrigins([]string{"*"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
-		handlers.ExposedHeaders([]string{"Content-Length"}),
+		handlers.AllowedOrigins(origins),
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "If-Match", "If-None-Match", "Last-Event-ID", RequestIDHeader}),
+		handlers.ExposedHeaders([]string{"Content-Length", "ETag", "Link", RequestIDHeader}),
 		handlers.MaxAge(3600),
 	)
-} 
\ No newline at end of file
+}
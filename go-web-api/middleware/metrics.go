@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/niphawanphoopha/go-web-api/metrics"
+)
+
+// MetricsMiddleware counts the requests served by router and observes their
+// latency, labelled by the template of the matched route, such as
+// /api/items/{id}, so the number of series does not grow with the IDs
+// requested. It wraps the whole router, as router.Use only runs for matched
+// routes, so requests matching none are counted as "unmatched".
+func MetricsMiddleware(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.RequestsInFlight.Inc()
+		defer metrics.RequestsInFlight.Dec()
+		start := time.Now()
+
+		rw := &responseWriter{w, http.StatusOK}
+		router.ServeHTTP(rw, r)
+
+		route := "unmatched"
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if template, err := match.Route.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		metrics.RequestsTotal.WithLabelValues(r.Method, route, strconv.Itoa(rw.statusCode)).Inc()
+		metrics.RequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/logging"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// permissionCacheTTL is how long resolved role permissions are reused
+const permissionCacheTTL = 30 * time.Second
+
+// cachedRole holds the resolved permissions of a role
+type cachedRole struct {
+	permissions map[string]bool
+	loadedAt    time.Time
+}
+
+var (
+	permissionCacheMu sync.RWMutex
+	permissionCache   = make(map[string]cachedRole)
+)
+
+// Roles is the role repository permissions are resolved from. It is set in
+// main once the store is opened.
+var Roles storage.RoleRepository
+
+// HasPermission checks if the role in the claims grants the named permission.
+// Permissions are resolved from the role repository, so role changes apply
+// without reissuing tokens.
+func HasPermission(claims *Claims, permission string) (bool, error) {
+	permissions, err := rolePermissions(claims.Role)
+	if err != nil {
+		return false, err
+	}
+	return permissions[permission], nil
+}
+
+// InvalidatePermissions drops all cached role permissions. It must be called
+// after roles or their permissions change.
+func InvalidatePermissions() {
+	permissionCacheMu.Lock()
+	permissionCache = make(map[string]cachedRole)
+	permissionCacheMu.Unlock()
+}
+
+// rolePermissions returns the permission set of a role, using the cache when fresh
+func rolePermissions(roleName string) (map[string]bool, error) {
+	permissionCacheMu.RLock()
+	cached, ok := permissionCache[roleName]
+	permissionCacheMu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < permissionCacheTTL {
+		return cached.permissions, nil
+	}
+
+	// Unknown roles have no permissions
+	permissions := make(map[string]bool)
+	role, err := Roles.Find(context.Background(), roleName)
+	if err != nil && err != storage.ErrNotFound {
+		return nil, err
+	}
+	if role != nil {
+		for _, p := range role.Permissions {
+			permissions[p.Name] = true
+		}
+	}
+
+	permissionCacheMu.Lock()
+	permissionCache[roleName] = cachedRole{permissions: permissions, loadedAt: time.Now()}
+	permissionCacheMu.Unlock()
+
+	return permissions, nil
+}
+
+// RequirePermission returns a middleware that only lets requests through
+// when the authenticated user's role grants the named permission
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Get the claims from the context
+			claims, ok := r.Context().Value("user").(*Claims)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			allowed, err := HasPermission(claims, permission)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Failed to check permissions", "error", err)
+				http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+)
+
+// bucket is a client's token bucket. It holds up to RATE_LIMIT_BURST tokens,
+// refills at RATE_LIMIT tokens a minute, and every request takes one.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a bucket per client IP
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// limiter is shared by every request, so a client's limit covers all routes
+var limiter = &rateLimiter{buckets: make(map[string]*bucket)}
+
+// RateLimitMiddleware limits each client IP to RATE_LIMIT requests a minute,
+// in bursts of up to RATE_LIMIT_BURST, and responds 429 Too Many Requests
+// beyond that. Both are read on every request, so reloads apply at once.
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := config.Current()
+		if cfg.RateLimit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Take a token from the client's bucket
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		wait := limiter.take(client, float64(cfg.RateLimit)/60, float64(cfg.RateLimitBurst), time.Now())
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// take refills a client's bucket at rate tokens a second up to burst and
+// takes a token from it. It returns 0 if there was a token, or how long until
+// there is one.
+func (l *rateLimiter) take(client string, rate, burst float64, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget the clients whose buckets have refilled, once a minute
+	if now.Sub(l.lastSweep) > time.Minute {
+		for key, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
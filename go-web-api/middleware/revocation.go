@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// RevocationStore keeps track of access tokens that must no longer be accepted
+type RevocationStore interface {
+	// IsRevoked reports whether the token described by claims was revoked,
+	// either individually or because all of its user's tokens were
+	IsRevoked(claims *Claims) (bool, error)
+	// Revoke revokes a single access token
+	Revoke(claims *Claims) error
+	// RevokeUser revokes every access and refresh token issued to a user so far
+	RevokeUser(userID uint) error
+}
+
+// Revocations is the revocation store checked by AuthMiddleware. It is set
+// in main once the store is opened.
+var Revocations RevocationStore
+
+// NewRevocationStore returns a RevocationStore that keeps revoked tokens in
+// the token repository and the users' revocation cutoffs in the user
+// repository
+func NewRevocationStore(store *storage.Store) RevocationStore {
+	return &storeRevocations{users: store.Users, tokens: store.Tokens}
+}
+
+// storeRevocations is a RevocationStore backed by the store
+type storeRevocations struct {
+	users  storage.UserRepository
+	tokens storage.TokenRepository
+}
+
+// IsRevoked checks the revoked token list and the user's revocation cutoff
+func (s *storeRevocations) IsRevoked(claims *Claims) (bool, error) {
+	revoked, err := s.tokens.IsRevoked(context.Background(), claims.Id)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	// Tokens of deleted users are revoked
+	user, err := s.users.Find(context.Background(), claims.UserID)
+	if err == storage.ErrNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Tokens issued up to the cutoff are revoked. Tokens without iat_ns,
+	// issued before it was added, are compared by the start of their second.
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+	if claims.IssuedAtNano != 0 {
+		issuedAt = time.Unix(0, claims.IssuedAtNano)
+	}
+	if user.TokensRevokedAt != nil && !issuedAt.After(*user.TokensRevokedAt) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Revoke adds the token to the revoked token list
+func (s *storeRevocations) Revoke(claims *Claims) error {
+	return s.tokens.Revoke(context.Background(), &models.RevokedToken{
+		JTI:       claims.Id,
+		UserID:    claims.UserID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	})
+}
+
+// RevokeUser moves the user's revocation cutoff to now and revokes all of
+// their refresh tokens
+func (s *storeRevocations) RevokeUser(userID uint) error {
+	now := time.Now()
+
+	// Access tokens are revoked first, so a failure leaves none usable
+	if err := s.users.RevokeTokens(context.Background(), userID, now); err != nil {
+		return err
+	}
+	return s.tokens.RevokeRefreshTokens(context.Background(), userID, now)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/database"
+)
+
+// migrateUsage describes the migrate command
+const migrateUsage = `Usage: go-web-api migrate <command>
+
+Commands:
+  up             Apply every pending migration
+  down [n]       Roll back the last n migrations (default: 1)
+  status         List migrations and when they were applied
+  create <name>  Write empty up and down files for a new migration, for
+                 every database driver
+`
+
+// migrationsDir is where migrate create writes new migrations, one directory
+// per database driver, relative to the repository root
+const migrationsDir = "database/migrations"
+
+// runMigrate runs the migrate command and returns the exit code
+func runMigrate(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	// Creating a migration does not need the database
+	if args[0] == "create" {
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, migrateUsage)
+			return 2
+		}
+		paths, err := database.CreateMigration(migrationsDir, args[1])
+		for _, path := range paths {
+			fmt.Printf("Created %s\n", path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create migration: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if err := database.Init(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := database.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to migrate database: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, "The number of migrations to roll back must be a positive integer")
+				return 2
+			}
+			steps = n
+		}
+		rolledBack, err := database.MigrateDown(steps)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to roll back migrations: %v\n", err)
+			return 1
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("No applied migrations")
+		}
+
+	case "status":
+		statuses, err := database.MigrationStatuses()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read migration status: %v\n", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			name, applied := status.Name, "pending"
+			if status.Unknown {
+				name = "(unknown to this build)"
+			}
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, name, applied)
+		}
+		w.Flush()
+
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
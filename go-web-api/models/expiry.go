@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ExpireItems returns moved items whose return time has passed and moves
+// items whose expiry time has passed to the trash. It returns the items that
+// were returned, as they are now, and the items that were expired.
+func ExpireItems(db *gorm.DB, now time.Time) (returned, expired []Item, err error) {
+	// Return moved items
+	var ids []uint
+	if err := db.Model(&Item{}).Where("returns_at <= ?", now).Pluck("id", &ids).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(ids) > 0 {
+		err := db.Model(&Item{}).Where("id IN (?) AND returns_at <= ?", ids, now).Updates(map[string]interface{}{
+			"moved_at":   nil,
+			"returns_at": nil,
+			"version":    gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := db.Where("id IN (?)", ids).Find(&returned).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Move expired items to the trash
+	if err := db.Where("expires_at <= ?", now).Find(&expired).Error; err != nil {
+		return returned, nil, err
+	}
+	if len(expired) > 0 {
+		ids = ids[:0]
+		for _, item := range expired {
+			ids = append(ids, item.ID)
+		}
+		if err := db.Where("id IN (?) AND expires_at <= ?", ids, now).Delete(&Item{}).Error; err != nil {
+			return returned, nil, err
+		}
+	}
+	return returned, expired, nil
+}
@@ -8,13 +8,23 @@ import (
 
 // Item represents data about a record Item.
 type Item struct {
-	gorm.Model
-	ID          string    `json:"id" gorm:"primary_key"`
-	Title       string    `json:"title" gorm:"not null"`
-	Description string    `json:"description"`
-	Price       float64   `json:"price" gorm:"not null"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          uint       `json:"id" gorm:"primary_key"`
+	Title       string     `json:"title" gorm:"not null"`
+	Description string     `json:"description"`
+	Price       float64    `json:"price" gorm:"not null"`
+	Type        string     `json:"type" gorm:"index"` // Category, e.g. Fruit or Vegetable
+	OwnerID     uint       `json:"owner_id" gorm:"index"`
+	Version     uint       `json:"version" gorm:"not null;default:1"` // Incremented on every update
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" sql:"index"`
+
+	// ExpiresAt moves the item to the trash once it has passed
+	ExpiresAt *time.Time `json:"expires_at" gorm:"index"`
+	// MovedAt is set while the item is moved into the column of its type
+	MovedAt *time.Time `json:"moved_at"`
+	// ReturnsAt moves a moved item back once it has passed
+	ReturnsAt *time.Time `json:"returns_at" gorm:"index"`
 }
 
 // TableName specifies the table name for the Item model
@@ -22,10 +32,20 @@ func (Item) TableName() string {
 	return "items"
 }
 
+// IsOwnedBy checks if the item belongs to the given user
+func (i *Item) IsOwnedBy(userID uint) bool {
+	return i.OwnerID == userID
+}
+
+// IsMoved checks if the item is moved into the column of its type
+func (i *Item) IsMoved() bool {
+	return i.MovedAt != nil
+}
+
 // BeforeCreate is a GORM hook that runs before creating a new item
 func (i *Item) BeforeCreate(scope *gorm.Scope) error {
-	// You can add custom logic here, like validation
-	return nil
+	// New items always start at the first version
+	return scope.SetColumn("Version", 1)
 }
 
 // BeforeUpdate is a GORM hook that runs before updating an item
@@ -34,9 +54,10 @@ func (i *Item) BeforeUpdate(scope *gorm.Scope) error {
 	return nil
 }
 
-// Items is a slice of Item to simulate a database.
+// Items are the fixture items created by the seed command. Their IDs are
+// assigned by the store.
 var Items = []Item{
-	{ID: "1", Title: "Item 1", Description: "This is item 1", Price: 19.99},
-	{ID: "2", Title: "Item 2", Description: "This is item 2", Price: 29.99},
-	{ID: "3", Title: "Item 3", Description: "This is item 3", Price: 39.99},
+	{Title: "Item 1", Description: "This is item 1", Price: 19.99},
+	{Title: "Item 2", Description: "This is item 2", Price: 29.99},
+	{Title: "Item 3", Description: "This is item 3", Price: 39.99},
 } 
\ No newline at end of file
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OutboxEvent is an event written in the same transaction as the change it
+// describes, and relayed to the event publishers once committed
+type OutboxEvent struct {
+	ID          uint       `json:"id" gorm:"primary_key"`
+	EventID     string     `json:"event_id" gorm:"unique;not null"` // Sent with the event so consumers can drop repeats
+	Topic       string     `json:"topic" gorm:"not null"`
+	Type        string     `json:"type" gorm:"not null"`
+	OwnerID     uint       `json:"owner_id"`
+	Data        string     `json:"data" gorm:"type:text;not null"`
+	Attempts    int        `json:"attempts" gorm:"not null"`
+	LastError   string     `json:"last_error"`
+	CreatedAt   time.Time  `json:"created_at"`
+	PublishedAt *time.Time `json:"published_at" gorm:"index"` // Set once every publisher has taken the event
+
+	// ClaimedUntil is set while an instance relays the event
+	ClaimedUntil *time.Time `json:"claimed_until"`
+	// PublishedTo lists the publishers that have taken the event, comma separated
+	PublishedTo string `json:"published_to" gorm:"not null"`
+}
+
+// TableName specifies the table name for the OutboxEvent model
+func (OutboxEvent) TableName() string {
+	return "outbox_events"
+}
+
+// PurgeOutbox deletes events that were published before the cutoff and
+// returns how many were deleted
+func PurgeOutbox(db *gorm.DB, cutoff time.Time) (int64, error) {
+	result := db.Where("published_at < ?", cutoff).Delete(&OutboxEvent{})
+	return result.RowsAffected, result.Error
+}
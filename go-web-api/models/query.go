@@ -0,0 +1,112 @@
+package models
+
+import "time"
+
+// SortField is a column to sort by and its direction
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ItemFilter describes which items to list and in which order
+type ItemFilter struct {
+	Deleted       bool // list the items in the trash instead
+	OwnerID       *uint
+	Query         string // matched against title and description
+	Type          string
+	Moved         *bool
+	MinPrice      *float64
+	MaxPrice      *float64
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Sort          []SortField
+	After         []interface{} // sort key values of the row before the page
+	Limit         int
+	Offset        int
+}
+
+// ItemSortFields lists the item columns that can be sorted by
+var ItemSortFields = []string{"id", "title", "price", "type", "created_at", "updated_at"}
+
+// TodoFilter describes which todos to list and in which order
+type TodoFilter struct {
+	Query  string // matched against the name
+	Type   string
+	State  string
+	Sort   []SortField
+	After  []interface{} // sort key values of the row before the page
+	Limit  int
+	Offset int
+}
+
+// TodoSortFields lists the todo columns that can be sorted by
+var TodoSortFields = []string{"id", "name", "type", "created_at", "updated_at"}
+
+// DeliveryFilter describes which deliveries of a webhook's log to list
+type DeliveryFilter struct {
+	WebhookID uint
+	Status    string
+	Limit     int
+	Offset    int
+}
+
+// UserFilter describes which users to list and in which order
+type UserFilter struct {
+	Deleted bool // list the users in the trash instead
+	Role    string
+	Sort    []SortField
+	After   []interface{} // sort key values of the row before the page
+	Limit   int
+	Offset  int
+}
+
+// UserSortFields lists the user columns that can be sorted by
+var UserSortFields = []string{"id", "username", "email", "created_at"}
+
+// ItemSortValue returns the value of a sortable item field
+func ItemSortValue(item Item, field string) interface{} {
+	switch field {
+	case "title":
+		return item.Title
+	case "price":
+		return item.Price
+	case "type":
+		return item.Type
+	case "created_at":
+		return item.CreatedAt
+	case "updated_at":
+		return item.UpdatedAt
+	default:
+		return item.ID
+	}
+}
+
+// TodoSortValue returns the value of a sortable todo field
+func TodoSortValue(todo Todo, field string) interface{} {
+	switch field {
+	case "name":
+		return todo.Name
+	case "type":
+		return todo.Type
+	case "created_at":
+		return todo.CreatedAt
+	case "updated_at":
+		return todo.UpdatedAt
+	default:
+		return todo.ID
+	}
+}
+
+// UserSortValue returns the value of a sortable user field
+func UserSortValue(user User, field string) interface{} {
+	switch field {
+	case "username":
+		return user.Username
+	case "email":
+		return user.Email
+	case "created_at":
+		return user.CreatedAt
+	default:
+		return user.ID
+	}
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// RefreshToken represents a persisted, single-use refresh token.
+// Tokens issued from the same login share a FamilyID so that the whole
+// chain can be revoked when a rotated token is replayed.
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"primary_key"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	TokenHash string     `json:"-" gorm:"unique;not null"` // Only the SHA-256 of the token is stored
+	FamilyID  string     `json:"family_id" gorm:"not null;index"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for the RefreshToken model
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// NewRefreshToken creates a new refresh token for a user in the given family.
+// It returns the record to persist and the raw token to hand to the client.
+func NewRefreshToken(userID uint, familyID string, ttl time.Duration) (*RefreshToken, string, error) {
+	raw, err := randomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &RefreshToken{
+		UserID:    userID,
+		TokenHash: HashToken(raw),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	return token, raw, nil
+}
+
+// NewTokenFamily returns a new random refresh token family ID
+func NewTokenFamily() (string, error) {
+	return randomHex(16)
+}
+
+// NewTokenID returns a new random identifier for use as a JWT ID (jti)
+func NewTokenID() (string, error) {
+	return randomHex(16)
+}
+
+// HashToken returns the hex-encoded SHA-256 hash of a raw token
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsExpired reports whether the refresh token has expired
+func (t *RefreshToken) IsExpired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// randomString returns n random bytes encoded as URL-safe base64
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// RevokedToken records an access token that was revoked before it expired.
+// Rows can be discarded once ExpiresAt has passed, because the token would
+// be rejected as expired anyway.
+type RevokedToken struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	JTI       string    `json:"jti" gorm:"unique;not null"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for the RevokedToken model
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Permission names checked by the API routes
+const (
+	PermItemsRead  = "items:read"
+	PermItemsWrite = "items:write"
+	PermItemsAdmin = "items:admin"
+	PermUsersRead  = "users:read"
+	PermUsersWrite = "users:write"
+	PermRolesRead  = "roles:read"
+	PermRolesWrite = "roles:write"
+	PermTodosRead  = "todos:read"
+	PermTodosWrite = "todos:write"
+	PermTodosAdmin = "todos:admin"
+
+	PermWebhooksRead  = "webhooks:read"
+	PermWebhooksWrite = "webhooks:write"
+)
+
+// Built-in role names
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Permission represents a named permission that can be granted to roles
+type Permission struct {
+	ID          uint   `json:"-" gorm:"primary_key"`
+	Name        string `json:"name" gorm:"unique;not null"`
+	Description string `json:"description"`
+}
+
+// TableName specifies the table name for the Permission model
+func (Permission) TableName() string {
+	return "permissions"
+}
+
+// Role represents a named set of permissions assigned to users
+type Role struct {
+	ID          uint         `json:"id" gorm:"primary_key"`
+	Name        string       `json:"name" gorm:"unique;not null"`
+	Description string       `json:"description"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// TableName specifies the table name for the Role model
+func (Role) TableName() string {
+	return "roles"
+}
+
+// HasPermission checks if the role grants the named permission
+func (r *Role) HasPermission(name string) bool {
+	for _, p := range r.Permissions {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions is the list of permissions known to the API
+var Permissions = []Permission{
+	{Name: PermItemsRead, Description: "View items"},
+	{Name: PermItemsWrite, Description: "Create, update and delete items"},
+	{Name: PermItemsAdmin, Description: "View and change items owned by other users"},
+	{Name: PermUsersRead, Description: "View users"},
+	{Name: PermUsersWrite, Description: "Update and delete users and assign their roles"},
+	{Name: PermRolesRead, Description: "View roles and permissions"},
+	{Name: PermRolesWrite, Description: "Create, update and delete roles"},
+	{Name: PermTodosRead, Description: "View todos and their history"},
+	{Name: PermTodosWrite, Description: "Create, update, delete and move todos"},
+	{Name: PermTodosAdmin, Description: "Manage the todo columns"},
+	{Name: PermWebhooksRead, Description: "View webhooks and their deliveries"},
+	{Name: PermWebhooksWrite, Description: "Create, update and delete webhooks and redeliver events"},
+}
+
+// DefaultUserPermissions are granted to the user role when it is first
+// created, or when the permission itself is first created
+var DefaultUserPermissions = []string{PermItemsRead, PermItemsWrite, PermTodosRead, PermTodosWrite}
+
+// SeedRoles makes sure every known permission exists, that the admin role
+// holds all of them, and that the default user role exists
+func SeedRoles(db *gorm.DB) error {
+	// Create missing permissions
+	all := make([]Permission, 0, len(Permissions))
+	var added []Permission
+	for _, p := range Permissions {
+		permission := p
+		isNew := db.Where("name = ?", p.Name).First(&Permission{}).RecordNotFound()
+		if err := db.Where(Permission{Name: p.Name}).Assign(Permission{Description: p.Description}).FirstOrCreate(&permission).Error; err != nil {
+			return err
+		}
+		all = append(all, permission)
+		if isNew && contains(DefaultUserPermissions, p.Name) {
+			added = append(added, permission)
+		}
+	}
+
+	// The admin role always holds every permission
+	admin := Role{Name: RoleAdmin}
+	if err := db.Where(Role{Name: RoleAdmin}).Attrs(Role{Description: "Full access"}).FirstOrCreate(&admin).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&admin).Association("Permissions").Replace(all).Error; err != nil {
+		return err
+	}
+
+	// The user role is only seeded once so admins can change it
+	var count int
+	if err := db.Model(&Role{}).Where("name = ?", RoleUser).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		var permissions []Permission
+		if err := db.Where("name IN (?)", DefaultUserPermissions).Find(&permissions).Error; err != nil {
+			return err
+		}
+		user := Role{Name: RoleUser, Description: "Default role for registered users", Permissions: permissions}
+		if err := db.Create(&user).Error; err != nil {
+			return err
+		}
+	} else if len(added) > 0 {
+		// Grant default permissions introduced since the role was created
+		var user Role
+		if err := db.Where("name = ?", RoleUser).First(&user).Error; err != nil {
+			return err
+		}
+		if err := db.Model(&user).Association("Permissions").Append(added).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// contains checks if a slice contains a string
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Todo states
+const (
+	// TodoStateMain is the state of a todo in the main list
+	TodoStateMain = "main"
+	// TodoStateMoved is the state of a todo moved into the column of its type
+	TodoStateMoved = "moved"
+)
+
+// Todo transition actions
+const (
+	TodoActionMove     = "move"
+	TodoActionMoveBack = "move_back"
+)
+
+// Todo represents an entry of the shared todo board. It sits in the main list
+// until it is moved into the column of its type.
+type Todo struct {
+	ID          uint       `json:"id" gorm:"primary_key"`
+	Name        string     `json:"name" gorm:"not null"`
+	Type        string     `json:"type" gorm:"not null;index"` // Name of a TodoType
+	State       string     `json:"state" gorm:"not null;default:'main';index"`
+	MovedAt     *time.Time `json:"moved_at"`
+	MovedByID   *uint      `json:"moved_by_id"`
+	CreatedByID uint       `json:"created_by_id" gorm:"index"`
+	Version     uint       `json:"version" gorm:"not null;default:1"` // Incremented on every update
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"-" sql:"index"`
+}
+
+// TableName specifies the table name for the Todo model
+func (Todo) TableName() string {
+	return "todos"
+}
+
+// IsMoved checks if the todo is moved into the column of its type
+func (t *Todo) IsMoved() bool {
+	return t.State == TodoStateMoved
+}
+
+// BeforeCreate is a GORM hook that runs before creating a new todo
+func (t *Todo) BeforeCreate(scope *gorm.Scope) error {
+	// New todos always start in the main list at the first version
+	if err := scope.SetColumn("State", TodoStateMain); err != nil {
+		return err
+	}
+	return scope.SetColumn("Version", 1)
+}
+
+// TodoType is a column of the todo board that todos of that type move into
+type TodoType struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	Name      string    `json:"name" gorm:"unique;not null"`
+	Position  int       `json:"position"` // Columns are shown in ascending order
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for the TodoType model
+func (TodoType) TableName() string {
+	return "todo_types"
+}
+
+// TodoTransition records a todo moving between the main list and its column
+type TodoTransition struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	TodoID    uint      `json:"todo_id" gorm:"not null;index"`
+	Action    string    `json:"action" gorm:"not null"`
+	FromState string    `json:"from_state" gorm:"not null"`
+	ToState   string    `json:"to_state" gorm:"not null"`
+	Type      string    `json:"type"` // Type of the todo at the time
+	UserID    uint      `json:"user_id" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name for the TodoTransition model
+func (TodoTransition) TableName() string {
+	return "todo_transitions"
+}
+
+// DefaultTodoTypes are the columns of a new todo board
+var DefaultTodoTypes = []TodoType{
+	{Name: "Fruit", Position: 1},
+	{Name: "Vegetable", Position: 2},
+}
+
+// SeedTodoTypes creates the default todo columns if there are none yet
+func SeedTodoTypes(db *gorm.DB) error {
+	var count int
+	if err := db.Model(&TodoType{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	for _, t := range DefaultTodoTypes {
+		todoType := t
+		if err := db.Create(&todoType).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
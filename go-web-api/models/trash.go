@@ -0,0 +1,30 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// PurgeItems permanently deletes the given items, whether or not they are in
+// the trash
+func PurgeItems(db *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.Unscoped().Where("id IN (?)", ids).Delete(&Item{}).Error
+}
+
+// PurgeUsers permanently deletes the given users together with their items
+// and tokens. It must run in a transaction so nothing is left half deleted.
+func PurgeUsers(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := tx.Unscoped().Where("owner_id IN (?)", ids).Delete(&Item{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id IN (?)", ids).Delete(&RefreshToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id IN (?)", ids).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id IN (?)", ids).Delete(&User{}).Error
+}
@@ -9,16 +9,20 @@ import (
 
 // User represents a user in the system
 type User struct {
-	gorm.Model
-	ID        uint      `json:"id" gorm:"primary_key"`
-	Username  string    `json:"username" gorm:"unique;not null"`
-	Email     string    `json:"email" gorm:"unique;not null"`
-	Password  string    `json:"-" gorm:"not null"` // The "-" means this field won't be included in JSON
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Role      string    `json:"role" gorm:"default:'user'"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uint       `json:"id" gorm:"primary_key"`
+	Username  string     `json:"username" gorm:"unique;not null"`
+	Email     string     `json:"email" gorm:"unique;not null"`
+	Password  string     `json:"-" gorm:"not null"` // The "-" means this field won't be included in JSON
+	FirstName string     `json:"first_name"`
+	LastName  string     `json:"last_name"`
+	Role      string     `json:"role" gorm:"default:'user'"`
+	Version   uint       `json:"version" gorm:"not null;default:1"` // Incremented on every update
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" sql:"index"`
+
+	// TokensRevokedAt revokes every token issued to the user up to this time
+	TokensRevokedAt *time.Time `json:"-"`
 }
 
 // TableName specifies the table name for the User model
@@ -30,28 +34,40 @@ func (User) TableName() string {
 func (u *User) BeforeCreate(scope *gorm.Scope) error {
 	// Hash the password before saving
 	if u.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		hashedPassword, err := HashPassword(u.Password)
 		if err != nil {
 			return err
 		}
-		scope.SetColumn("Password", string(hashedPassword))
+		scope.SetColumn("Password", hashedPassword)
 	}
-	return nil
+	
+	// New users always start at the first version
+	return scope.SetColumn("Version", 1)
 }
 
 // BeforeUpdate is a GORM hook that runs before updating a user
 func (u *User) BeforeUpdate(scope *gorm.Scope) error {
-	// Hash the password if it's being updated
+	// Hash the password if it's being updated. A loaded user already holds a
+	// bcrypt hash, which must not be hashed again.
+	if _, err := bcrypt.Cost([]byte(u.Password)); err == nil {
+		return nil
+	}
 	if u.Password != "" && scope.HasColumn("Password") {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		hashedPassword, err := HashPassword(u.Password)
 		if err != nil {
 			return err
 		}
-		scope.SetColumn("Password", string(hashedPassword))
+		scope.SetColumn("Password", hashedPassword)
 	}
 	return nil
 }
 
+// HashPassword returns the bcrypt hash of a password
+func HashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hashedPassword), err
+}
+
 // CheckPassword checks if the provided password matches the hashed password
 func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
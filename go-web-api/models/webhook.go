@@ -0,0 +1,72 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL that is sent the events matching its filters
+type Webhook struct {
+	ID          uint      `json:"id" gorm:"primary_key"`
+	URL         string    `json:"url" gorm:"not null"`
+	Secret      string    `json:"secret,omitempty" gorm:"not null"` // Only returned when set
+	Events      []string  `json:"events" gorm:"-"`                  // Event types or wildcards such as "item.*"
+	EventFilter string    `json:"-" gorm:"column:events;not null"`  // Events joined with commas
+	Active      bool      `json:"active" gorm:"not null"`
+	CreatedByID uint      `json:"created_by_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for the Webhook model
+func (Webhook) TableName() string {
+	return "webhooks"
+}
+
+// BeforeSave is a GORM hook that stores the event filters in a single column
+func (h *Webhook) BeforeSave(scope *gorm.Scope) error {
+	h.EventFilter = strings.Join(h.Events, ",")
+	return nil
+}
+
+// AfterFind is a GORM hook that splits the stored event filters
+func (h *Webhook) AfterFind(scope *gorm.Scope) error {
+	h.Events = []string{}
+	if h.EventFilter != "" {
+		h.Events = strings.Split(h.EventFilter, ",")
+	}
+	return nil
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook, along with
+// the outcome of the last attempt
+type WebhookDelivery struct {
+	ID            uint       `json:"id" gorm:"primary_key"`
+	WebhookID     uint       `json:"webhook_id" gorm:"index;not null"`
+	EventID       string     `json:"event_id" gorm:"index;not null"` // Shared by redeliveries of the same event
+	EventType     string     `json:"event_type" gorm:"not null"`
+	Payload       string     `json:"payload" gorm:"type:text;not null"`
+	Status        string     `json:"status" gorm:"index;not null"`
+	Attempts      int        `json:"attempts" gorm:"not null"`
+	ResponseCode  int        `json:"response_code"`
+	ResponseBody  string     `json:"response_body" gorm:"type:text"`
+	Error         string     `json:"error"`
+	NextAttemptAt *time.Time `json:"next_attempt_at" gorm:"index"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for the WebhookDelivery model
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
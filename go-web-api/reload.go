@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/logging"
+)
+
+// reloadDelay is how long the config file must stay unchanged before it is
+// reloaded, so a save that writes it in several steps reloads once
+const reloadDelay = 250 * time.Millisecond
+
+// reloadMu keeps a SIGHUP and a file change from reloading at the same time
+var reloadMu sync.Mutex
+
+// reloadConfig loads the configuration again from the config file,
+// environment and flags, and applies the settings that can change without a
+// restart. An invalid configuration is rejected and the current one kept.
+func reloadConfig() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	changed, ignored, err := config.Reload(os.Args[1:])
+	if err != nil {
+		log.Printf("Configuration reload rejected: %v", err)
+		return
+	}
+	if len(ignored) > 0 {
+		log.Printf("Configuration changes that need a restart were ignored: %s", strings.Join(ignored, ", "))
+	}
+	if len(changed) == 0 {
+		log.Println("Configuration reloaded, nothing changed")
+		return
+	}
+
+	// Most settings are read from the snapshot on every request; the pool
+	// sizes have to be applied to the database, and the level to the logger
+	database.Configure(config.Current())
+	logging.Configure(config.Current())
+	log.Printf("Configuration reloaded, changed: %s", strings.Join(changed, ", "))
+}
+
+// watchConfigFile reloads the configuration whenever the config file
+// changes, until the context is cancelled. It watches the file's directory,
+// because editors and Kubernetes replace the file rather than write to it.
+func watchConfigFile(ctx context.Context, path string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		name := filepath.Clean(path)
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-watcher.Events:
+				// Kubernetes swaps a ..data symlink to update mounted files
+				if filepath.Clean(event.Name) == name || strings.Contains(event.Name, "..data") {
+					timer.Reset(reloadDelay)
+				}
+			case err := <-watcher.Errors:
+				log.Printf("Config file watcher failed: %v", err)
+			case <-timer.C:
+				reloadConfig()
+			}
+		}
+	}()
+	return nil
+}
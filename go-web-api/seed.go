@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// seedUsage describes the seed command
+const seedUsage = `Usage: go-web-api seed [--owner <username>]
+
+Seeds the built-in roles and todo columns, and the fixture items unless
+there are items already, so seeding again adds nothing.
+
+Flags:
+  --owner <username>  Give the fixture items to this user
+`
+
+// runSeed runs the seed command and returns the exit code
+func runSeed(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	owner := fs.String("owner", "", "")
+	if rest, err := parseArgs(fs, args); err != nil || len(rest) > 0 {
+		fmt.Fprint(os.Stderr, seedUsage)
+		return 2
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+	ctx := context.Background()
+
+	// Find the owner of the items
+	var ownerID uint
+	if *owner != "" {
+		user, err := store.Users.FindByUsername(ctx, *owner)
+		if err == storage.ErrNotFound {
+			fmt.Fprintf(os.Stderr, "No user %s\n", *owner)
+			return 1
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to find user: %v\n", err)
+			return 1
+		}
+		ownerID = user.ID
+	}
+
+	// Leave existing items alone
+	count, err := store.Items.Count(ctx, models.ItemFilter{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to count items: %v\n", err)
+		return 1
+	}
+	if count > 0 {
+		fmt.Printf("Found %d items, not seeding the fixture items\n", count)
+		return 0
+	}
+
+	// Create the fixture items, numbered by the store
+	for _, fixture := range models.Items {
+		item := models.Item{
+			Title:       fixture.Title,
+			Description: fixture.Description,
+			Price:       fixture.Price,
+			Type:        fixture.Type,
+			OwnerID:     ownerID,
+		}
+		if err := store.Items.Create(ctx, &item); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create item %q: %v\n", item.Title, err)
+			return 1
+		}
+		fmt.Printf("Created item %d: %s\n", item.ID, item.Title)
+	}
+	return 0
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	
+	"github.com/niphawanphoopha/go-web-api/api"
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/events"
+	"github.com/niphawanphoopha/go-web-api/jobs"
+	"github.com/niphawanphoopha/go-web-api/metrics"
+	"github.com/niphawanphoopha/go-web-api/middleware"
+	"github.com/niphawanphoopha/go-web-api/storage"
+	"github.com/niphawanphoopha/go-web-api/webhooks"
+)
+
+// serveUsage describes the serve command
+const serveUsage = `Usage: go-web-api serve
+
+Starts the API server. It runs until interrupted, and reloads its
+configuration on SIGHUP.
+`
+
+// runServe runs the API server until it is shut down and returns the exit
+// code
+func runServe(cfg *config.Config, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprint(os.Stderr, serveUsage)
+		return 2
+	}
+	
+	// Load JWT signing keys
+	if err := middleware.InitKeys(cfg); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	
+	// Sign pagination cursors with a key of this process unless one is
+	// configured, so they cannot be forged with the JWT configuration
+	if cfg.CursorSecret.Value() == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Failed to generate cursor secret: %v", err)
+		}
+		cfg.CursorSecret.Set(base64.RawURLEncoding.EncodeToString(key))
+		log.Println("CURSOR_SECRET is not set; pagination cursors are only valid on this instance until it restarts")
+	}
+	
+	// Initialize the database, unless everything is kept in memory
+	if cfg.Storage == "database" {
+		if err := database.Init(cfg); err != nil {
+			log.Fatalf("Failed to initialize database: %v", err)
+		}
+		defer database.Close()
+		
+		// Export the connection pool stats as metrics
+		dbName := cfg.DBName
+		if cfg.DBDriver == "sqlite" {
+			dbName = cfg.DBPath
+		}
+		if err := metrics.RegisterDB(database.DB.DB(), dbName); err != nil {
+			log.Fatalf("Failed to register database metrics: %v", err)
+		}
+		
+		// Apply pending migrations if asked to, then refuse to run against an
+		// older schema
+		if cfg.DBAutoMigrate {
+			if _, err := database.MigrateUp(); err != nil {
+				log.Fatalf("Failed to migrate database: %v", err)
+			}
+		}
+		if err := database.CheckSchema(context.Background()); err != nil {
+			log.Fatalf("Refusing to start: %v", err)
+		}
+	} else {
+		log.Println("Keeping all data in memory; it is lost when the server stops")
+	}
+	
+	// Open the store
+	store, err := storage.New(cfg, database.DB)
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	middleware.Roles = store.Roles
+	middleware.Revocations = middleware.NewRevocationStore(store)
+	
+	// Seed permissions, built-in roles and the default todo columns
+	if err := store.Roles.Seed(context.Background()); err != nil {
+		log.Fatalf("Failed to seed roles: %v", err)
+	}
+	if err := store.TodoTypes.Seed(context.Background()); err != nil {
+		log.Fatalf("Failed to seed todo types: %v", err)
+	}
+	
+	// Keep recent events for clients resuming their event stream
+	events.Init(cfg.EventLogSize)
+	
+	// Queue webhook deliveries for every relayed event, and log them in debug mode
+	events.AddPublisher("webhooks", webhooks.Enqueuer(store.Webhooks))
+	events.AddPublisher("log", func(event events.Event) error {
+		if !config.Current().Debug {
+			return nil
+		}
+		return events.LogEvent(event)
+	})
+	
+	// Start background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	jobs.StartTrashPurger(jobsCtx, cfg, store)
+	jobs.StartItemExpiry(jobsCtx, cfg, store)
+	jobs.StartWebhookDelivery(jobsCtx, cfg, store)
+	if cfg.Storage == "database" {
+		// The memory store publishes its events as it makes its changes
+		jobs.StartOutboxRelay(jobsCtx, cfg, database.DB)
+	}
+	jobs.StartSecretRefresh(jobsCtx, cfg)
+	
+	// Reload the configuration when the config file changes
+	if cfg.File != "" {
+		if err := watchConfigFile(jobsCtx, cfg.File); err != nil {
+			log.Printf("Failed to watch config file, reload with SIGHUP instead: %v", err)
+		}
+	}
+	
+	// Create a new server
+	router := api.SetupRoutes(cfg, store)
+	server := &http.Server{
+		Addr:         fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Handler:      router,
+		ReadTimeout:  time.Duration(cfg.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
+	}
+	
+	// End open event streams on shutdown instead of waiting for them
+	server.RegisterOnShutdown(events.Default.Close)
+	
+	// Start the server in a goroutine
+	go func() {
+		log.Printf("Server starting on %s:%d...\n", cfg.Host, cfg.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+	
+	// Serve metrics on their own address, so they stay off the public API
+	var metricsServer *http.Server
+	if cfg.MetricsAddr != "" {
+		metricsServer = &http.Server{
+			Addr:         cfg.MetricsAddr,
+			Handler:      api.SetupMetricsRoutes(),
+			ReadTimeout:  time.Duration(cfg.ReadTimeout) * time.Second,
+			WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
+		}
+		go func() {
+			log.Printf("Metrics server starting on %s...\n", cfg.MetricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start metrics server: %v", err)
+			}
+		}()
+	}
+	
+	// Reload the configuration on SIGHUP, and wait for interrupt signal to
+	// gracefully shut down the server
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range quit {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloadConfig()
+	}
+	
+	log.Println("Shutting down server...")
+	stopJobs()
+	
+	// Create a deadline for server shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	
+	// Shutdown the server
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatalf("Server forced to shutdown: %v", err)
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(ctx)
+	}
+	
+	log.Println("Server exiting")
+	return 0
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/events"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// NewGormStore returns a store backed by the database. Changes are recorded
+// in the event outbox in the same transaction, so no event is lost.
+func NewGormStore(db *gorm.DB) *Store {
+	return &Store{
+		Items:     &gormItems{db},
+		Users:     &gormUsers{db},
+		Roles:     &gormRoles{db},
+		Tokens:    &gormTokens{db},
+		Todos:     &gormTodos{db},
+		TodoTypes: &gormTodoTypes{db},
+		Webhooks:  &gormWebhooks{db},
+	}
+}
+
+// gormItems is an ItemRepository backed by the database
+type gormItems struct {
+	db *gorm.DB
+}
+
+// with returns the database handle logging queries with the context's logger
+func (r *gormItems) with(ctx context.Context) *gorm.DB {
+	return database.WithContext(ctx, r.db)
+}
+
+// Find returns an item that is not in the trash
+func (r *gormItems) Find(ctx context.Context, id uint) (*models.Item, error) {
+	var item models.Item
+	if err := findError(r.with(ctx).Where("id = ?", id).First(&item)); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// FindDeleted returns an item that is in the trash
+func (r *gormItems) FindDeleted(ctx context.Context, id uint) (*models.Item, error) {
+	var item models.Item
+	if err := findError(r.with(ctx).Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&item)); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// List returns a page of the items matching a filter
+func (r *gormItems) List(ctx context.Context, filter models.ItemFilter) ([]models.Item, error) {
+	items := []models.Item{}
+	query := applyItemFilter(r.with(ctx).Model(&models.Item{}), filter)
+	err := Paginate(query, filter.Sort, filter.After, filter.Limit, filter.Offset).Find(&items).Error
+	return items, err
+}
+
+// Count returns how many items match a filter
+func (r *gormItems) Count(ctx context.Context, filter models.ItemFilter) (int, error) {
+	var total int
+	err := applyItemFilter(r.with(ctx).Model(&models.Item{}), filter).Count(&total).Error
+	return total, err
+}
+
+// Create saves a new item and records it as created
+func (r *gormItems) Create(ctx context.Context, item *models.Item) error {
+	return CreateWithEvent(r.with(ctx), item, events.Created)
+}
+
+// Update changes an item unless it changed since it was read, and records it
+// as updated
+func (r *gormItems) Update(ctx context.Context, item *models.Item, changes map[string]interface{}) (bool, error) {
+	return UpdateWithEvent(r.with(ctx), item, item.Version, changes, events.Updated)
+}
+
+// Delete moves an item to the trash unless it changed since it was read, and
+// records it as deleted
+func (r *gormItems) Delete(ctx context.Context, item *models.Item) (bool, error) {
+	return DeleteWithEvent(r.with(ctx), item, item.Version, events.Deleted)
+}
+
+// Restore clears the deletion time of an item, and its expiry time if it has
+// passed, unless it changed since it was read, and records it as restored
+func (r *gormItems) Restore(ctx context.Context, item *models.Item) (bool, error) {
+	return UpdateWithEvent(r.with(ctx).Unscoped(), item, item.Version, restoreChanges(item, time.Now()), events.Restored)
+}
+
+// Purge permanently deletes items
+func (r *gormItems) Purge(ctx context.Context, ids []uint) error {
+	return models.PurgeItems(r.with(ctx), ids)
+}
+
+// PurgeTrash permanently deletes the items moved to the trash before the
+// cutoff
+func (r *gormItems) PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	var ids []uint
+	if err := r.with(ctx).Unscoped().Model(&models.Item{}).Where("deleted_at < ?", cutoff).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if err := models.PurgeItems(r.with(ctx), ids); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// Expire returns moved items and trashes expired items, recording the
+// changes in the outbox in the same transaction
+func (r *gormItems) Expire(ctx context.Context, now time.Time) (returned, expired []models.Item, err error) {
+	err = r.with(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if returned, expired, err = models.ExpireItems(tx, now); err != nil {
+			return err
+		}
+		for i := range returned {
+			if err := events.Record(tx, events.Updated, &returned[i]); err != nil {
+				return err
+			}
+		}
+		for i := range expired {
+			if err := events.Record(tx, events.Deleted, &expired[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(returned) > 0 || len(expired) > 0 {
+		events.Notify()
+	}
+	return returned, expired, nil
+}
+
+// gormUsers is a UserRepository backed by the database
+type gormUsers struct {
+	db *gorm.DB
+}
+
+// with returns the database handle logging queries with the context's logger
+func (r *gormUsers) with(ctx context.Context) *gorm.DB {
+	return database.WithContext(ctx, r.db)
+}
+
+// Find returns a user that is not in the trash
+func (r *gormUsers) Find(ctx context.Context, id uint) (*models.User, error) {
+	return r.findWhere(ctx, false, "id = ?", id)
+}
+
+// FindDeleted returns a user that is in the trash
+func (r *gormUsers) FindDeleted(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	if err := findError(r.with(ctx).Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&user)); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindByUsername returns the user with a username
+func (r *gormUsers) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.findWhere(ctx, false, "username = ?", username)
+}
+
+// FindByEmail returns the user with an email, even in the trash
+func (r *gormUsers) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findWhere(ctx, true, "email = ?", email)
+}
+
+// FindByUsernameOrEmail returns a user with either the username or the
+// email, even in the trash
+func (r *gormUsers) FindByUsernameOrEmail(ctx context.Context, username, email string) (*models.User, error) {
+	return r.findWhere(ctx, true, "username = ? OR email = ?", username, email)
+}
+
+// List returns a page of the users matching a filter
+func (r *gormUsers) List(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+	users := []models.User{}
+	query := applyUserFilter(r.with(ctx).Model(&models.User{}), filter)
+	err := Paginate(query, filter.Sort, filter.After, filter.Limit, filter.Offset).Find(&users).Error
+	return users, err
+}
+
+// Count returns how many users match a filter
+func (r *gormUsers) Count(ctx context.Context, filter models.UserFilter) (int, error) {
+	var total int
+	err := applyUserFilter(r.with(ctx).Model(&models.User{}), filter).Count(&total).Error
+	return total, err
+}
+
+// Create saves a new user, whose password is hashed by the BeforeCreate
+// hook, and records it as registered
+func (r *gormUsers) Create(ctx context.Context, user *models.User) error {
+	return CreateWithEvent(r.with(ctx), user, events.Registered)
+}
+
+// Update changes a user unless it changed since it was read, and records it
+// as updated
+func (r *gormUsers) Update(ctx context.Context, user *models.User, changes map[string]interface{}) (bool, error) {
+	return UpdateWithEvent(r.with(ctx), user, user.Version, changes, events.Updated)
+}
+
+// Delete moves a user to the trash unless it changed since it was read, and
+// records it as deleted
+func (r *gormUsers) Delete(ctx context.Context, user *models.User) (bool, error) {
+	return DeleteWithEvent(r.with(ctx), user, user.Version, events.Deleted)
+}
+
+// Restore clears the deletion time of a user unless it changed since it was
+// read, and records it as restored
+func (r *gormUsers) Restore(ctx context.Context, user *models.User) (bool, error) {
+	return UpdateWithEvent(r.with(ctx).Unscoped(), user, user.Version, map[string]interface{}{"deleted_at": nil}, events.Restored)
+}
+
+// Purge permanently deletes users together with their items and tokens
+func (r *gormUsers) Purge(ctx context.Context, ids []uint) error {
+	return r.with(ctx).Transaction(func(tx *gorm.DB) error {
+		return models.PurgeUsers(tx, ids)
+	})
+}
+
+// PurgeTrash permanently deletes the users moved to the trash before the
+// cutoff
+func (r *gormUsers) PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	var ids []uint
+	if err := r.with(ctx).Unscoped().Model(&models.User{}).Where("deleted_at < ?", cutoff).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if err := r.Purge(ctx, ids); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// RevokeTokens moves a user's token revocation cutoff
+func (r *gormUsers) RevokeTokens(ctx context.Context, id uint, at time.Time) error {
+	return r.with(ctx).Unscoped().Model(&models.User{}).Where("id = ?", id).UpdateColumn("tokens_revoked_at", at).Error
+}
+
+// findWhere returns the first user matching a condition. Users in the trash
+// are only found when unscoped is set.
+func (r *gormUsers) findWhere(ctx context.Context, unscoped bool, condition string, args ...interface{}) (*models.User, error) {
+	db := r.with(ctx)
+	if unscoped {
+		db = db.Unscoped()
+	}
+	var user models.User
+	if err := findError(db.Where(condition, args...).First(&user)); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// findError turns a missing row into ErrNotFound
+func findError(query *gorm.DB) error {
+	if query.RecordNotFound() {
+		return ErrNotFound
+	}
+	return query.Error
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// gormRoles is a RoleRepository backed by the database
+type gormRoles struct {
+	db *gorm.DB
+}
+
+// with returns the database handle logging queries with the context's logger
+func (r *gormRoles) with(ctx context.Context) *gorm.DB {
+	return database.WithContext(ctx, r.db)
+}
+
+// Permissions returns every permission, ordered by name
+func (r *gormRoles) Permissions(ctx context.Context) ([]models.Permission, error) {
+	permissions := []models.Permission{}
+	err := r.with(ctx).Order("name").Find(&permissions).Error
+	return permissions, err
+}
+
+// FindPermissions returns those of the named permissions that exist
+func (r *gormRoles) FindPermissions(ctx context.Context, names []string) ([]models.Permission, error) {
+	permissions := []models.Permission{}
+	if len(names) == 0 {
+		return permissions, nil
+	}
+	err := r.with(ctx).Where("name IN (?)", names).Find(&permissions).Error
+	return permissions, err
+}
+
+// List returns every role with its permissions, ordered by name
+func (r *gormRoles) List(ctx context.Context) ([]models.Role, error) {
+	roles := []models.Role{}
+	err := r.with(ctx).Preload("Permissions").Order("name").Find(&roles).Error
+	return roles, err
+}
+
+// Find returns the role with a name, with its permissions
+func (r *gormRoles) Find(ctx context.Context, name string) (*models.Role, error) {
+	var role models.Role
+	if err := findError(r.with(ctx).Preload("Permissions").Where("name = ?", name).First(&role)); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// Create saves a new role and links its permissions
+func (r *gormRoles) Create(ctx context.Context, role *models.Role) error {
+	return r.with(ctx).Create(role).Error
+}
+
+// Update saves the description of a role and replaces its permission links
+// in a transaction
+func (r *gormRoles) Update(ctx context.Context, role *models.Role) error {
+	permissions := role.Permissions
+	return r.with(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Set("gorm:save_associations", false).Model(role).Update("description", role.Description).Error; err != nil {
+			return err
+		}
+		return tx.Model(role).Association("Permissions").Replace(permissions).Error
+	})
+}
+
+// Delete deletes a role and its permission links in a transaction
+func (r *gormRoles) Delete(ctx context.Context, role *models.Role) error {
+	return r.with(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(role).Association("Permissions").Clear().Error; err != nil {
+			return err
+		}
+		return tx.Delete(role).Error
+	})
+}
+
+// Seed seeds the permissions and built-in roles
+func (r *gormRoles) Seed(ctx context.Context) error {
+	return models.SeedRoles(r.with(ctx))
+}
+
+// gormTokens is a TokenRepository backed by the database
+type gormTokens struct {
+	db *gorm.DB
+}
+
+// with returns the database handle logging queries with the context's logger
+func (r *gormTokens) with(ctx context.Context) *gorm.DB {
+	return database.WithContext(ctx, r.db)
+}
+
+// CreateRefreshToken saves a new refresh token
+func (r *gormTokens) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	return r.with(ctx).Create(token).Error
+}
+
+// FindRefreshToken returns the refresh token with a hash
+func (r *gormTokens) FindRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := findError(r.with(ctx).Where("token_hash = ?", hash).First(&token)); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// UseRefreshToken marks a refresh token as used. The used_at condition makes
+// it atomic.
+func (r *gormTokens) UseRefreshToken(ctx context.Context, id uint, at time.Time) (bool, error) {
+	result := r.with(ctx).Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// RevokeFamily revokes every refresh token of a family
+func (r *gormTokens) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	return r.with(ctx).Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", at).Error
+}
+
+// RevokeRefreshTokens revokes every refresh token of a user
+func (r *gormTokens) RevokeRefreshTokens(ctx context.Context, userID uint, at time.Time) error {
+	return r.with(ctx).Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", at).Error
+}
+
+// IsRevoked reports whether the access token with an ID was revoked
+func (r *gormTokens) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int
+	if err := r.with(ctx).Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Revoke adds an access token to the revoked list
+func (r *gormTokens) Revoke(ctx context.Context, token *models.RevokedToken) error {
+	// Drop entries for tokens that have expired on their own
+	if err := r.with(ctx).Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return r.with(ctx).Create(token).Error
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/events"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// gormTodos is a TodoRepository backed by the database
+type gormTodos struct {
+	db *gorm.DB
+}
+
+// with returns the database handle logging queries with the context's logger
+func (r *gormTodos) with(ctx context.Context) *gorm.DB {
+	return database.WithContext(ctx, r.db)
+}
+
+// Find returns a todo
+func (r *gormTodos) Find(ctx context.Context, id uint) (*models.Todo, error) {
+	var todo models.Todo
+	if err := findError(r.with(ctx).Where("id = ?", id).First(&todo)); err != nil {
+		return nil, err
+	}
+	return &todo, nil
+}
+
+// List returns a page of the todos matching a filter
+func (r *gormTodos) List(ctx context.Context, filter models.TodoFilter) ([]models.Todo, error) {
+	todos := []models.Todo{}
+	query := applyTodoFilter(r.with(ctx).Model(&models.Todo{}), filter)
+	err := Paginate(query, filter.Sort, filter.After, filter.Limit, filter.Offset).Find(&todos).Error
+	return todos, err
+}
+
+// Count returns how many todos match a filter
+func (r *gormTodos) Count(ctx context.Context, filter models.TodoFilter) (int, error) {
+	var total int
+	err := applyTodoFilter(r.with(ctx).Model(&models.Todo{}), filter).Count(&total).Error
+	return total, err
+}
+
+// ListState returns the todos in a state in the order they arrived in it
+func (r *gormTodos) ListState(ctx context.Context, state string) ([]models.Todo, error) {
+	order := "updated_at ASC, id ASC"
+	if state == models.TodoStateMoved {
+		order = "moved_at ASC, id ASC"
+	}
+	todos := []models.Todo{}
+	err := r.with(ctx).Where("state = ?", state).Order(order).Find(&todos).Error
+	return todos, err
+}
+
+// Create saves a new todo, whose state and version are set by the
+// BeforeCreate hook, and records it as created
+func (r *gormTodos) Create(ctx context.Context, todo *models.Todo) error {
+	return CreateWithEvent(r.with(ctx), todo, events.Created)
+}
+
+// Update changes a todo unless it changed since it was read, and records it
+// as updated
+func (r *gormTodos) Update(ctx context.Context, todo *models.Todo, changes map[string]interface{}) (bool, error) {
+	return UpdateWithEvent(r.with(ctx), todo, todo.Version, changes, events.Updated)
+}
+
+// Delete deletes a todo unless it changed since it was read, and records it
+// as deleted
+func (r *gormTodos) Delete(ctx context.Context, todo *models.Todo) (bool, error) {
+	return DeleteWithEvent(r.with(ctx), todo, todo.Version, events.Deleted)
+}
+
+// Transition changes a todo unless it changed since it was read, and records
+// the transition and the change in the same transaction
+func (r *gormTodos) Transition(ctx context.Context, todo *models.Todo, changes map[string]interface{}, transition *models.TodoTransition) (bool, error) {
+	var saved bool
+	err := r.with(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if saved, err = UpdateVersioned(tx, todo, todo.Version, changes); err != nil || !saved {
+			return err
+		}
+		if err := tx.Create(transition).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, events.Updated, todo)
+	})
+	if err != nil {
+		return false, err
+	}
+	if saved {
+		events.Notify()
+	}
+	return saved, nil
+}
+
+// Transitions returns the transitions of a todo, oldest first
+func (r *gormTodos) Transitions(ctx context.Context, todoID uint) ([]models.TodoTransition, error) {
+	transitions := []models.TodoTransition{}
+	err := r.with(ctx).Where("todo_id = ?", todoID).Order("created_at ASC, id ASC").Find(&transitions).Error
+	return transitions, err
+}
+
+// gormTodoTypes is a TodoTypeRepository backed by the database
+type gormTodoTypes struct {
+	db *gorm.DB
+}
+
+// with returns the database handle logging queries with the context's logger
+func (r *gormTodoTypes) with(ctx context.Context) *gorm.DB {
+	return database.WithContext(ctx, r.db)
+}
+
+// List returns every todo type, ordered by position
+func (r *gormTodoTypes) List(ctx context.Context) ([]models.TodoType, error) {
+	types := []models.TodoType{}
+	err := r.with(ctx).Order("position ASC, id ASC").Find(&types).Error
+	return types, err
+}
+
+// Find returns the todo type with a name
+func (r *gormTodoTypes) Find(ctx context.Context, name string) (*models.TodoType, error) {
+	var todoType models.TodoType
+	if err := findError(r.with(ctx).Where("name = ?", name).First(&todoType)); err != nil {
+		return nil, err
+	}
+	return &todoType, nil
+}
+
+// Create saves a new todo type
+func (r *gormTodoTypes) Create(ctx context.Context, todoType *models.TodoType) error {
+	return r.with(ctx).Create(todoType).Error
+}
+
+// Update saves a todo type and renames its todos in the same transaction
+func (r *gormTodoTypes) Update(ctx context.Context, todoType *models.TodoType, oldName string) error {
+	return r.with(ctx).Transaction(func(tx *gorm.DB) error {
+		if todoType.Name != oldName {
+			if err := tx.Unscoped().Model(&models.Todo{}).Where("type = ?", oldName).UpdateColumn("type", todoType.Name).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(todoType).Error
+	})
+}
+
+// Delete deletes a todo type
+func (r *gormTodoTypes) Delete(ctx context.Context, todoType *models.TodoType) error {
+	return r.with(ctx).Delete(todoType).Error
+}
+
+// Seed creates the default todo types
+func (r *gormTodoTypes) Seed(ctx context.Context) error {
+	return models.SeedTodoTypes(r.with(ctx))
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// gormWebhooks is a WebhookRepository backed by the database
+type gormWebhooks struct {
+	db *gorm.DB
+}
+
+// with returns the database handle logging queries with the context's logger
+func (r *gormWebhooks) with(ctx context.Context) *gorm.DB {
+	return database.WithContext(ctx, r.db)
+}
+
+// List returns every webhook, ordered by ID
+func (r *gormWebhooks) List(ctx context.Context) ([]models.Webhook, error) {
+	hooks := []models.Webhook{}
+	err := r.with(ctx).Order("id").Find(&hooks).Error
+	return hooks, err
+}
+
+// ListActive returns the active webhooks
+func (r *gormWebhooks) ListActive(ctx context.Context) ([]models.Webhook, error) {
+	hooks := []models.Webhook{}
+	err := r.with(ctx).Where("active = ?", true).Order("id").Find(&hooks).Error
+	return hooks, err
+}
+
+// Find returns a webhook
+func (r *gormWebhooks) Find(ctx context.Context, id uint) (*models.Webhook, error) {
+	var hook models.Webhook
+	if err := findError(r.with(ctx).Where("id = ?", id).First(&hook)); err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+// Create saves a new webhook
+func (r *gormWebhooks) Create(ctx context.Context, hook *models.Webhook) error {
+	return r.with(ctx).Create(hook).Error
+}
+
+// Update saves a webhook
+func (r *gormWebhooks) Update(ctx context.Context, hook *models.Webhook) error {
+	return r.with(ctx).Save(hook).Error
+}
+
+// Delete deletes a webhook and its deliveries in a transaction
+func (r *gormWebhooks) Delete(ctx context.Context, hook *models.Webhook) error {
+	return r.with(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", hook.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(hook).Error
+	})
+}
+
+// FindDelivery returns a delivery from a webhook's log
+func (r *gormWebhooks) FindDelivery(ctx context.Context, webhookID, id uint) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := findError(r.with(ctx).Where("id = ? AND webhook_id = ?", id, webhookID).First(&delivery)); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ListDeliveries returns a page of the deliveries matching a filter, most
+// recent first
+func (r *gormWebhooks) ListDeliveries(ctx context.Context, filter models.DeliveryFilter) ([]models.WebhookDelivery, error) {
+	deliveries := []models.WebhookDelivery{}
+	err := r.deliveries(ctx, filter).Order("id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// CountDeliveries returns how many deliveries match a filter
+func (r *gormWebhooks) CountDeliveries(ctx context.Context, filter models.DeliveryFilter) (int, error) {
+	var total int
+	err := r.deliveries(ctx, filter).Count(&total).Error
+	return total, err
+}
+
+// QueuedWebhooks returns the IDs of the webhooks an event was queued for
+func (r *gormWebhooks) QueuedWebhooks(ctx context.Context, eventID string) ([]uint, error) {
+	var ids []uint
+	err := r.with(ctx).Model(&models.WebhookDelivery{}).Where("event_id = ?", eventID).Pluck("webhook_id", &ids).Error
+	return ids, err
+}
+
+// CreateDelivery saves a new delivery
+func (r *gormWebhooks) CreateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return r.with(ctx).Create(delivery).Error
+}
+
+// DueDeliveries returns pending deliveries whose next attempt is due
+func (r *gormWebhooks) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	due := []models.WebhookDelivery{}
+	err := r.with(ctx).Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+		Order("next_attempt_at ASC, id ASC").Limit(limit).Find(&due).Error
+	return due, err
+}
+
+// ClaimDelivery moves the next attempt of a due delivery. The conditions make
+// the claim atomic across instances.
+func (r *gormWebhooks) ClaimDelivery(ctx context.Context, id uint, now, until time.Time) (bool, error) {
+	result := r.with(ctx).Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, models.DeliveryPending, now).
+		UpdateColumn("next_attempt_at", until)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// SaveDelivery saves the outcome of an attempt
+func (r *gormWebhooks) SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return r.with(ctx).Save(delivery).Error
+}
+
+// deliveries returns the query selecting the deliveries matching a filter
+func (r *gormWebhooks) deliveries(ctx context.Context, filter models.DeliveryFilter) *gorm.DB {
+	query := r.with(ctx).Model(&models.WebhookDelivery{}).Where("webhook_id = ?", filter.WebhookID)
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	return query
+}
@@ -0,0 +1,740 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/events"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// NewMemoryStore returns a store that keeps every table in memory, for
+// tests and local development. Everything is lost when the process exits.
+// Changes are published straight to the event publishers, with no outbox.
+func NewMemoryStore() *Store {
+	m := &memoryStore{
+		items:         make(map[uint]models.Item),
+		users:         make(map[uint]models.User),
+		permissions:   make(map[uint]models.Permission),
+		roles:         make(map[uint]models.Role),
+		refreshTokens: make(map[uint]models.RefreshToken),
+		revokedTokens: make(map[string]models.RevokedToken),
+		todos:         make(map[uint]models.Todo),
+		todoTypes:     make(map[uint]models.TodoType),
+		webhooks:      make(map[uint]models.Webhook),
+		deliveries:    make(map[uint]models.WebhookDelivery),
+	}
+	return &Store{
+		Items:     &memoryItems{m},
+		Users:     &memoryUsers{m},
+		Roles:     &memoryRoles{m},
+		Tokens:    &memoryTokens{m},
+		Todos:     &memoryTodos{m},
+		TodoTypes: &memoryTodoTypes{m},
+		Webhooks:  &memoryWebhooks{m},
+	}
+}
+
+// memoryStore holds the rows of every repository behind one lock, so
+// changes spanning tables, such as purging a user with their items and
+// tokens, are atomic
+type memoryStore struct {
+	mu            sync.RWMutex
+	items         map[uint]models.Item
+	users         map[uint]models.User
+	permissions   map[uint]models.Permission
+	roles         map[uint]models.Role
+	refreshTokens map[uint]models.RefreshToken
+	revokedTokens map[string]models.RevokedToken // by token ID
+	todos         map[uint]models.Todo
+	transitions   []models.TodoTransition // in the order they were recorded
+	todoTypes     map[uint]models.TodoType
+	webhooks      map[uint]models.Webhook
+	deliveries    map[uint]models.WebhookDelivery
+
+	lastItemID       uint
+	lastUserID       uint
+	lastPermissionID uint
+	lastRoleID       uint
+	lastTokenID      uint
+	lastTodoID       uint
+	lastTransitionID uint
+	lastTodoTypeID   uint
+	lastWebhookID    uint
+	lastDeliveryID   uint
+}
+
+// memoryItems is an ItemRepository kept in memory
+type memoryItems struct {
+	*memoryStore
+}
+
+// Find returns an item that is not in the trash
+func (m *memoryItems) Find(ctx context.Context, id uint) (*models.Item, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	item, ok := m.items[id]
+	if !ok || item.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	return &item, nil
+}
+
+// FindDeleted returns an item that is in the trash
+func (m *memoryItems) FindDeleted(ctx context.Context, id uint) (*models.Item, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	item, ok := m.items[id]
+	if !ok || item.DeletedAt == nil {
+		return nil, ErrNotFound
+	}
+	return &item, nil
+}
+
+// List returns a page of the items matching a filter
+func (m *memoryItems) List(ctx context.Context, filter models.ItemFilter) ([]models.Item, error) {
+	m.mu.RLock()
+	items := []models.Item{}
+	for _, item := range m.items {
+		if matchItem(item, filter) {
+			items = append(items, item)
+		}
+	}
+	m.mu.RUnlock()
+	return paginate(items, filter.Sort, filter.After, filter.Limit, filter.Offset, models.ItemSortValue), nil
+}
+
+// Count returns how many items match a filter
+func (m *memoryItems) Count(ctx context.Context, filter models.ItemFilter) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	total := 0
+	for _, item := range m.items {
+		if matchItem(item, filter) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// Create saves a new item and publishes it as created
+func (m *memoryItems) Create(ctx context.Context, item *models.Item) error {
+	m.mu.Lock()
+	if item.ID == 0 {
+		m.lastItemID++
+		item.ID = m.lastItemID
+	} else if _, ok := m.items[item.ID]; ok {
+		m.mu.Unlock()
+		return fmt.Errorf("item %d already exists", item.ID)
+	} else if item.ID > m.lastItemID {
+		m.lastItemID = item.ID
+	}
+	now := time.Now()
+	item.Version = 1
+	item.CreatedAt, item.UpdatedAt = now, now
+	m.items[item.ID] = *item
+	m.mu.Unlock()
+
+	publish(events.Created, item)
+	return nil
+}
+
+// Update changes an item unless it changed since it was read, and publishes
+// it as updated
+func (m *memoryItems) Update(ctx context.Context, item *models.Item, changes map[string]interface{}) (bool, error) {
+	return m.update(item, changes, false, events.Updated)
+}
+
+// Delete moves an item to the trash unless it changed since it was read, and
+// publishes it as deleted
+func (m *memoryItems) Delete(ctx context.Context, item *models.Item) (bool, error) {
+	m.mu.Lock()
+	stored, ok := m.items[item.ID]
+	if !ok || stored.DeletedAt != nil || stored.Version != item.Version {
+		m.mu.Unlock()
+		return false, nil
+	}
+	now := time.Now()
+	stored.DeletedAt = &now
+	m.items[item.ID] = stored
+	*item = stored
+	m.mu.Unlock()
+
+	publish(events.Deleted, item)
+	return true, nil
+}
+
+// Restore clears the deletion time of an item, and its expiry time if it has
+// passed, unless it changed since it was read, and publishes it as restored
+func (m *memoryItems) Restore(ctx context.Context, item *models.Item) (bool, error) {
+	return m.update(item, restoreChanges(item, time.Now()), true, events.Restored)
+}
+
+// Purge permanently deletes items
+func (m *memoryItems) Purge(ctx context.Context, ids []uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		delete(m.items, id)
+	}
+	return nil
+}
+
+// PurgeTrash permanently deletes the items moved to the trash before the
+// cutoff
+func (m *memoryItems) PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	purged := 0
+	for id, item := range m.items {
+		if item.DeletedAt != nil && item.DeletedAt.Before(cutoff) {
+			delete(m.items, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// Expire returns moved items and trashes expired items, then publishes the
+// changes
+func (m *memoryItems) Expire(ctx context.Context, now time.Time) (returned, expired []models.Item, err error) {
+	m.mu.Lock()
+	for id, item := range m.items {
+		if item.DeletedAt != nil {
+			continue
+		}
+		if item.ReturnsAt != nil && !item.ReturnsAt.After(now) {
+			item.MovedAt, item.ReturnsAt = nil, nil
+			item.Version++
+			item.UpdatedAt = time.Now()
+			m.items[id] = item
+			returned = append(returned, item)
+		}
+		if item.ExpiresAt != nil && !item.ExpiresAt.After(now) {
+			// Expired items are reported as they were before being trashed
+			expired = append(expired, item)
+			deletedAt := time.Now()
+			item.DeletedAt = &deletedAt
+			m.items[id] = item
+		}
+	}
+	m.mu.Unlock()
+
+	for i := range returned {
+		publish(events.Updated, &returned[i])
+	}
+	for i := range expired {
+		publish(events.Deleted, &expired[i])
+	}
+	return returned, expired, nil
+}
+
+// update applies changes to an item unless it changed since it was read.
+// Items in the trash are only changed when unscoped is set.
+func (m *memoryItems) update(item *models.Item, changes map[string]interface{}, unscoped bool, change string) (bool, error) {
+	m.mu.Lock()
+	stored, ok := m.items[item.ID]
+	if !ok || (stored.DeletedAt != nil && !unscoped) || stored.Version != item.Version {
+		m.mu.Unlock()
+		return false, nil
+	}
+	if err := applyItemChanges(&stored, changes); err != nil {
+		m.mu.Unlock()
+		return false, err
+	}
+	stored.Version++
+	stored.UpdatedAt = time.Now()
+	m.items[item.ID] = stored
+	*item = stored
+	m.mu.Unlock()
+
+	publish(change, item)
+	return true, nil
+}
+
+// memoryUsers is a UserRepository kept in memory
+type memoryUsers struct {
+	*memoryStore
+}
+
+// Find returns a user that is not in the trash
+func (m *memoryUsers) Find(ctx context.Context, id uint) (*models.User, error) {
+	return m.findWhere(false, func(user models.User) bool { return user.ID == id })
+}
+
+// FindDeleted returns a user that is in the trash
+func (m *memoryUsers) FindDeleted(ctx context.Context, id uint) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt == nil {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+// FindByUsername returns the user with a username
+func (m *memoryUsers) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return m.findWhere(false, func(user models.User) bool { return user.Username == username })
+}
+
+// FindByEmail returns the user with an email, even in the trash
+func (m *memoryUsers) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return m.findWhere(true, func(user models.User) bool { return user.Email == email })
+}
+
+// FindByUsernameOrEmail returns a user with either the username or the
+// email, even in the trash
+func (m *memoryUsers) FindByUsernameOrEmail(ctx context.Context, username, email string) (*models.User, error) {
+	return m.findWhere(true, func(user models.User) bool { return user.Username == username || user.Email == email })
+}
+
+// List returns a page of the users matching a filter
+func (m *memoryUsers) List(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+	m.mu.RLock()
+	users := []models.User{}
+	for _, user := range m.users {
+		if matchUser(user, filter) {
+			users = append(users, user)
+		}
+	}
+	m.mu.RUnlock()
+	return paginate(users, filter.Sort, filter.After, filter.Limit, filter.Offset, models.UserSortValue), nil
+}
+
+// Count returns how many users match a filter
+func (m *memoryUsers) Count(ctx context.Context, filter models.UserFilter) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	total := 0
+	for _, user := range m.users {
+		if matchUser(user, filter) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// Create hashes the password, saves a new user and publishes it as
+// registered. Usernames and emails are unique, as in the database.
+func (m *memoryUsers) Create(ctx context.Context, user *models.User) error {
+	if user.Password != "" {
+		hashedPassword, err := models.HashPassword(user.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = hashedPassword
+	}
+	if user.Role == "" {
+		user.Role = "user"
+	}
+
+	m.mu.Lock()
+	if err := m.checkUnique(0, user.Username, user.Email); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	if user.ID == 0 {
+		m.lastUserID++
+		user.ID = m.lastUserID
+	} else if _, ok := m.users[user.ID]; ok {
+		m.mu.Unlock()
+		return fmt.Errorf("user %d already exists", user.ID)
+	} else if user.ID > m.lastUserID {
+		m.lastUserID = user.ID
+	}
+	now := time.Now()
+	user.Version = 1
+	user.CreatedAt, user.UpdatedAt = now, now
+	m.users[user.ID] = *user
+	m.mu.Unlock()
+
+	publish(events.Registered, user)
+	return nil
+}
+
+// Update changes a user unless it changed since it was read, and publishes
+// it as updated
+func (m *memoryUsers) Update(ctx context.Context, user *models.User, changes map[string]interface{}) (bool, error) {
+	return m.update(user, changes, false, events.Updated)
+}
+
+// Delete moves a user to the trash unless it changed since it was read, and
+// publishes it as deleted
+func (m *memoryUsers) Delete(ctx context.Context, user *models.User) (bool, error) {
+	m.mu.Lock()
+	stored, ok := m.users[user.ID]
+	if !ok || stored.DeletedAt != nil || stored.Version != user.Version {
+		m.mu.Unlock()
+		return false, nil
+	}
+	now := time.Now()
+	stored.DeletedAt = &now
+	m.users[user.ID] = stored
+	*user = stored
+	m.mu.Unlock()
+
+	publish(events.Deleted, user)
+	return true, nil
+}
+
+// Restore clears the deletion time of a user unless it changed since it was
+// read, and publishes it as restored
+func (m *memoryUsers) Restore(ctx context.Context, user *models.User) (bool, error) {
+	return m.update(user, map[string]interface{}{"deleted_at": nil}, true, events.Restored)
+}
+
+// Purge permanently deletes users together with their items and tokens
+func (m *memoryUsers) Purge(ctx context.Context, ids []uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purgeUsers(ids)
+	return nil
+}
+
+// PurgeTrash permanently deletes the users moved to the trash before the
+// cutoff
+func (m *memoryUsers) PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []uint
+	for id, user := range m.users {
+		if user.DeletedAt != nil && user.DeletedAt.Before(cutoff) {
+			ids = append(ids, id)
+		}
+	}
+	m.purgeUsers(ids)
+	return len(ids), nil
+}
+
+// RevokeTokens moves a user's token revocation cutoff
+func (m *memoryUsers) RevokeTokens(ctx context.Context, id uint, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[id]; ok {
+		user.TokensRevokedAt = &at
+		m.users[id] = user
+	}
+	return nil
+}
+
+// findWhere returns the first user that matches. Users in the trash are
+// only found when unscoped is set.
+func (m *memoryUsers) findWhere(unscoped bool, match func(models.User) bool) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, user := range m.users {
+		if (unscoped || user.DeletedAt == nil) && match(user) {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// update applies changes to a user unless it changed since it was read.
+// Users in the trash are only changed when unscoped is set.
+func (m *memoryUsers) update(user *models.User, changes map[string]interface{}, unscoped bool, change string) (bool, error) {
+	m.mu.Lock()
+	stored, ok := m.users[user.ID]
+	if !ok || (stored.DeletedAt != nil && !unscoped) || stored.Version != user.Version {
+		m.mu.Unlock()
+		return false, nil
+	}
+	if err := applyUserChanges(&stored, changes); err != nil {
+		m.mu.Unlock()
+		return false, err
+	}
+	if err := m.checkUnique(stored.ID, stored.Username, stored.Email); err != nil {
+		m.mu.Unlock()
+		return false, err
+	}
+	stored.Version++
+	stored.UpdatedAt = time.Now()
+	m.users[user.ID] = stored
+	*user = stored
+	m.mu.Unlock()
+
+	publish(change, user)
+	return true, nil
+}
+
+// checkUnique returns an error if another user, even in the trash, has the
+// username or the email. The lock must be held.
+func (m *memoryUsers) checkUnique(id uint, username, email string) error {
+	for _, user := range m.users {
+		if user.ID == id {
+			continue
+		}
+		if user.Username == username {
+			return fmt.Errorf("username %q already exists", username)
+		}
+		if user.Email == email {
+			return fmt.Errorf("email %q already exists", email)
+		}
+	}
+	return nil
+}
+
+// purgeUsers deletes users and their items and tokens. The lock must be
+// held.
+func (m *memoryStore) purgeUsers(ids []uint) {
+	for _, id := range ids {
+		for itemID, item := range m.items {
+			if item.OwnerID == id {
+				delete(m.items, itemID)
+			}
+		}
+		for tokenID, token := range m.refreshTokens {
+			if token.UserID == id {
+				delete(m.refreshTokens, tokenID)
+			}
+		}
+		for jti, token := range m.revokedTokens {
+			if token.UserID == id {
+				delete(m.revokedTokens, jti)
+			}
+		}
+		delete(m.users, id)
+	}
+}
+
+// matchItem checks if an item matches the conditions of a filter
+func matchItem(item models.Item, filter models.ItemFilter) bool {
+	if (item.DeletedAt != nil) != filter.Deleted {
+		return false
+	}
+	if filter.OwnerID != nil && item.OwnerID != *filter.OwnerID {
+		return false
+	}
+	if filter.Query != "" {
+		query := strings.ToLower(filter.Query)
+		if !strings.Contains(strings.ToLower(item.Title), query) && !strings.Contains(strings.ToLower(item.Description), query) {
+			return false
+		}
+	}
+	if filter.Type != "" && item.Type != filter.Type {
+		return false
+	}
+	if filter.Moved != nil && item.IsMoved() != *filter.Moved {
+		return false
+	}
+	if filter.MinPrice != nil && item.Price < *filter.MinPrice {
+		return false
+	}
+	if filter.MaxPrice != nil && item.Price > *filter.MaxPrice {
+		return false
+	}
+	if filter.CreatedAfter != nil && item.CreatedAt.Before(*filter.CreatedAfter) {
+		return false
+	}
+	if filter.CreatedBefore != nil && !item.CreatedAt.Before(*filter.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// matchUser checks if a user matches the conditions of a filter
+func matchUser(user models.User, filter models.UserFilter) bool {
+	if (user.DeletedAt != nil) != filter.Deleted {
+		return false
+	}
+	return filter.Role == "" || user.Role == filter.Role
+}
+
+// paginate sorts rows by the sort fields and returns the page after the
+// keyset values if given, otherwise after the offset, like Paginate does in
+// SQL
+func paginate[T any](rows []T, fields []models.SortField, after []interface{}, limit, offset int, value func(T, string) interface{}) []T {
+	compare := func(row T, values []interface{}) int {
+		for i, field := range fields {
+			c := compareValues(value(row, field.Field), values[i])
+			if field.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		values := make([]interface{}, len(fields))
+		for k, field := range fields {
+			values[k] = value(rows[j], field.Field)
+		}
+		return compare(rows[i], values) < 0
+	})
+
+	if after != nil {
+		page := rows[:0]
+		for _, row := range rows {
+			if compare(row, after) > 0 {
+				page = append(page, row)
+			}
+		}
+		rows = page
+	} else if offset > 0 {
+		if offset > len(rows) {
+			offset = len(rows)
+		}
+		rows = rows[offset:]
+	}
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+// compareValues compares two sort key values: strings, times or numbers of
+// any type, such as IDs decoded from a cursor as float64
+func compareValues(a, b interface{}) int {
+	switch x := a.(type) {
+	case string:
+		y, _ := b.(string)
+		return strings.Compare(x, y)
+	case time.Time:
+		y, _ := b.(time.Time)
+		return x.Compare(y)
+	}
+	x, y := toFloat(a), toFloat(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// toFloat converts a numeric sort key value to float64
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case uint:
+		return float64(n)
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// applyItemChanges sets the item fields named by the column names of a
+// change set
+func applyItemChanges(item *models.Item, changes map[string]interface{}) error {
+	for column, value := range changes {
+		var err error
+		switch column {
+		case "title":
+			item.Title, err = stringValue(column, value)
+		case "description":
+			item.Description, err = stringValue(column, value)
+		case "type":
+			item.Type, err = stringValue(column, value)
+		case "price":
+			price, ok := value.(float64)
+			if !ok {
+				err = fmt.Errorf("invalid value for price: %v", value)
+			}
+			item.Price = price
+		case "expires_at":
+			item.ExpiresAt, err = timeValue(column, value)
+		case "moved_at":
+			item.MovedAt, err = timeValue(column, value)
+		case "returns_at":
+			item.ReturnsAt, err = timeValue(column, value)
+		case "deleted_at":
+			item.DeletedAt, err = timeValue(column, value)
+		case "version":
+			// Bumped by update
+		default:
+			err = fmt.Errorf("unknown item column %q", column)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyUserChanges sets the user fields named by the column names of a
+// change set, hashing a new password
+func applyUserChanges(user *models.User, changes map[string]interface{}) error {
+	for column, value := range changes {
+		var err error
+		switch column {
+		case "username":
+			user.Username, err = stringValue(column, value)
+		case "email":
+			user.Email, err = stringValue(column, value)
+		case "first_name":
+			user.FirstName, err = stringValue(column, value)
+		case "last_name":
+			user.LastName, err = stringValue(column, value)
+		case "role":
+			user.Role, err = stringValue(column, value)
+		case "password":
+			var password string
+			if password, err = stringValue(column, value); err == nil {
+				// A bcrypt hash is stored as is, like the BeforeUpdate hook does
+				if _, costErr := bcrypt.Cost([]byte(password)); costErr != nil {
+					password, err = models.HashPassword(password)
+				}
+				user.Password = password
+			}
+		case "tokens_revoked_at":
+			user.TokensRevokedAt, err = timeValue(column, value)
+		case "deleted_at":
+			user.DeletedAt, err = timeValue(column, value)
+		case "version":
+			// Bumped by update
+		default:
+			err = fmt.Errorf("unknown user column %q", column)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stringValue returns the value of a string column
+func stringValue(column string, value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid value for %s: %v", column, value)
+	}
+	return s, nil
+}
+
+// timeValue returns the value of a nullable time column, given as a
+// time.Time, a *time.Time or nil
+func timeValue(column string, value interface{}) (*time.Time, error) {
+	switch t := value.(type) {
+	case nil:
+		return nil, nil
+	case *time.Time:
+		return t, nil
+	case time.Time:
+		return &t, nil
+	}
+	return nil, fmt.Errorf("invalid value for %s: %v", column, value)
+}
+
+// publish sends the event for a change made in memory, logging failures
+// since there is no outbox to retry from
+func publish(change string, resource interface{}) {
+	if err := events.Publish(change, resource); err != nil {
+		log.Printf("Failed to publish event: %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// memoryRoles is a RoleRepository kept in memory
+type memoryRoles struct {
+	*memoryStore
+}
+
+// Permissions returns every permission, ordered by name
+func (m *memoryRoles) Permissions(ctx context.Context) ([]models.Permission, error) {
+	m.mu.RLock()
+	permissions := make([]models.Permission, 0, len(m.permissions))
+	for _, p := range m.permissions {
+		permissions = append(permissions, p)
+	}
+	m.mu.RUnlock()
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i].Name < permissions[j].Name })
+	return permissions, nil
+}
+
+// FindPermissions returns those of the named permissions that exist
+func (m *memoryRoles) FindPermissions(ctx context.Context, names []string) ([]models.Permission, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.findPermissions(names), nil
+}
+
+// List returns every role with its permissions, ordered by name
+func (m *memoryRoles) List(ctx context.Context) ([]models.Role, error) {
+	m.mu.RLock()
+	roles := make([]models.Role, 0, len(m.roles))
+	for _, role := range m.roles {
+		roles = append(roles, copyRole(role))
+	}
+	m.mu.RUnlock()
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+// Find returns the role with a name, with its permissions
+func (m *memoryRoles) Find(ctx context.Context, name string) (*models.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	role, ok := m.findRole(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	role = copyRole(role)
+	return &role, nil
+}
+
+// Create saves a new role. Role names are unique, as in the database.
+func (m *memoryRoles) Create(ctx context.Context, role *models.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.createRole(role)
+}
+
+// Update saves the description of a role and replaces its permissions
+func (m *memoryRoles) Update(ctx context.Context, role *models.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.roles[role.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Description = role.Description
+	stored.Permissions = append([]models.Permission{}, role.Permissions...)
+	stored.UpdatedAt = time.Now()
+	m.roles[role.ID] = stored
+	*role = copyRole(stored)
+	return nil
+}
+
+// Delete deletes a role
+func (m *memoryRoles) Delete(ctx context.Context, role *models.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.roles, role.ID)
+	return nil
+}
+
+// Seed makes sure every known permission exists, that the admin role holds
+// all of them, and that the default user role exists, like SeedRoles
+func (m *memoryRoles) Seed(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Create missing permissions
+	var added []string
+	for _, p := range models.Permissions {
+		if existing := m.findPermissions([]string{p.Name}); len(existing) > 0 {
+			existing[0].Description = p.Description
+			m.permissions[existing[0].ID] = existing[0]
+			continue
+		}
+		m.lastPermissionID++
+		p.ID = m.lastPermissionID
+		m.permissions[p.ID] = p
+		added = append(added, p.Name)
+	}
+	all := make([]string, 0, len(models.Permissions))
+	for _, p := range models.Permissions {
+		all = append(all, p.Name)
+	}
+
+	// The admin role always holds every permission
+	admin, ok := m.findRole(models.RoleAdmin)
+	if !ok {
+		admin = models.Role{Name: models.RoleAdmin, Description: "Full access"}
+		m.createRole(&admin)
+	}
+	admin.Permissions = m.findPermissions(all)
+	m.roles[admin.ID] = admin
+
+	// The user role is only seeded once so admins can change it
+	user, ok := m.findRole(models.RoleUser)
+	if !ok {
+		user = models.Role{Name: models.RoleUser, Description: "Default role for registered users", Permissions: m.findPermissions(models.DefaultUserPermissions)}
+		m.createRole(&user)
+		return nil
+	}
+
+	// Grant default permissions introduced since the role was created
+	for _, p := range m.findPermissions(added) {
+		if contains(models.DefaultUserPermissions, p.Name) {
+			user.Permissions = append(user.Permissions, p)
+		}
+	}
+	m.roles[user.ID] = user
+	return nil
+}
+
+// findPermissions returns those of the named permissions that exist, in
+// the order they were created. The lock must be held.
+func (m *memoryRoles) findPermissions(names []string) []models.Permission {
+	permissions := []models.Permission{}
+	for _, p := range m.permissions {
+		if contains(names, p.Name) {
+			permissions = append(permissions, p)
+		}
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i].ID < permissions[j].ID })
+	return permissions
+}
+
+// findRole returns the role with a name. The lock must be held.
+func (m *memoryRoles) findRole(name string) (models.Role, bool) {
+	for _, role := range m.roles {
+		if role.Name == name {
+			return role, true
+		}
+	}
+	return models.Role{}, false
+}
+
+// createRole saves a new role. The lock must be held.
+func (m *memoryRoles) createRole(role *models.Role) error {
+	if _, ok := m.findRole(role.Name); ok {
+		return fmt.Errorf("role %q already exists", role.Name)
+	}
+	m.lastRoleID++
+	role.ID = m.lastRoleID
+	now := time.Now()
+	role.CreatedAt, role.UpdatedAt = now, now
+	m.roles[role.ID] = copyRole(*role)
+	return nil
+}
+
+// copyRole returns a role with its own copy of the permission list, so the
+// caller cannot change the stored one
+func copyRole(role models.Role) models.Role {
+	role.Permissions = append([]models.Permission{}, role.Permissions...)
+	return role
+}
+
+// memoryTokens is a TokenRepository kept in memory
+type memoryTokens struct {
+	*memoryStore
+}
+
+// CreateRefreshToken saves a new refresh token
+func (m *memoryTokens) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastTokenID++
+	token.ID = m.lastTokenID
+	token.CreatedAt = time.Now()
+	m.refreshTokens[token.ID] = *token
+	return nil
+}
+
+// FindRefreshToken returns the refresh token with a hash
+func (m *memoryTokens) FindRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, token := range m.refreshTokens {
+		if token.TokenHash == hash {
+			return &token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// UseRefreshToken marks a refresh token as used unless it already was
+func (m *memoryTokens) UseRefreshToken(ctx context.Context, id uint, at time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.refreshTokens[id]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &at
+	m.refreshTokens[id] = token
+	return true, nil
+}
+
+// RevokeFamily revokes every refresh token of a family
+func (m *memoryTokens) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	m.revokeWhere(at, func(token models.RefreshToken) bool { return token.FamilyID == familyID })
+	return nil
+}
+
+// RevokeRefreshTokens revokes every refresh token of a user
+func (m *memoryTokens) RevokeRefreshTokens(ctx context.Context, userID uint, at time.Time) error {
+	m.revokeWhere(at, func(token models.RefreshToken) bool { return token.UserID == userID })
+	return nil
+}
+
+// IsRevoked reports whether the access token with an ID was revoked
+func (m *memoryTokens) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.revokedTokens[jti]
+	return ok, nil
+}
+
+// Revoke adds an access token to the revoked list
+func (m *memoryTokens) Revoke(ctx context.Context, token *models.RevokedToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Drop entries for tokens that have expired on their own
+	now := time.Now()
+	for jti, revoked := range m.revokedTokens {
+		if revoked.ExpiresAt.Before(now) {
+			delete(m.revokedTokens, jti)
+		}
+	}
+
+	if _, ok := m.revokedTokens[token.JTI]; ok {
+		return fmt.Errorf("token %s is already revoked", token.JTI)
+	}
+	token.CreatedAt = now
+	m.revokedTokens[token.JTI] = *token
+	return nil
+}
+
+// revokeWhere revokes the refresh tokens that match and are not revoked yet
+func (m *memoryTokens) revokeWhere(at time.Time, match func(models.RefreshToken) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, token := range m.refreshTokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &at
+			m.refreshTokens[id] = token
+		}
+	}
+}
+
+// contains checks if a list contains a string
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
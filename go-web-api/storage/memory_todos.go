@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/events"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// memoryTodos is a TodoRepository kept in memory
+type memoryTodos struct {
+	*memoryStore
+}
+
+// Find returns a todo
+func (m *memoryTodos) Find(ctx context.Context, id uint) (*models.Todo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	todo, ok := m.todos[id]
+	if !ok || todo.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	return &todo, nil
+}
+
+// List returns a page of the todos matching a filter
+func (m *memoryTodos) List(ctx context.Context, filter models.TodoFilter) ([]models.Todo, error) {
+	m.mu.RLock()
+	todos := []models.Todo{}
+	for _, todo := range m.todos {
+		if matchTodo(todo, filter) {
+			todos = append(todos, todo)
+		}
+	}
+	m.mu.RUnlock()
+	return paginate(todos, filter.Sort, filter.After, filter.Limit, filter.Offset, models.TodoSortValue), nil
+}
+
+// Count returns how many todos match a filter
+func (m *memoryTodos) Count(ctx context.Context, filter models.TodoFilter) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	total := 0
+	for _, todo := range m.todos {
+		if matchTodo(todo, filter) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// ListState returns the todos in a state in the order they arrived in it
+func (m *memoryTodos) ListState(ctx context.Context, state string) ([]models.Todo, error) {
+	m.mu.RLock()
+	todos := []models.Todo{}
+	for _, todo := range m.todos {
+		if todo.DeletedAt == nil && todo.State == state {
+			todos = append(todos, todo)
+		}
+	}
+	m.mu.RUnlock()
+
+	arrivedAt := func(todo models.Todo) time.Time {
+		if state == models.TodoStateMoved && todo.MovedAt != nil {
+			return *todo.MovedAt
+		}
+		return todo.UpdatedAt
+	}
+	sort.Slice(todos, func(i, j int) bool {
+		if c := arrivedAt(todos[i]).Compare(arrivedAt(todos[j])); c != 0 {
+			return c < 0
+		}
+		return todos[i].ID < todos[j].ID
+	})
+	return todos, nil
+}
+
+// Create saves a new todo in the main list and publishes it as created
+func (m *memoryTodos) Create(ctx context.Context, todo *models.Todo) error {
+	m.mu.Lock()
+	m.lastTodoID++
+	todo.ID = m.lastTodoID
+	now := time.Now()
+	todo.State = models.TodoStateMain
+	todo.Version = 1
+	todo.CreatedAt, todo.UpdatedAt = now, now
+	m.todos[todo.ID] = *todo
+	m.mu.Unlock()
+
+	publish(events.Created, todo)
+	return nil
+}
+
+// Update changes a todo unless it changed since it was read, and publishes
+// it as updated
+func (m *memoryTodos) Update(ctx context.Context, todo *models.Todo, changes map[string]interface{}) (bool, error) {
+	return m.Transition(ctx, todo, changes, nil)
+}
+
+// Delete deletes a todo unless it changed since it was read, and publishes
+// it as deleted
+func (m *memoryTodos) Delete(ctx context.Context, todo *models.Todo) (bool, error) {
+	m.mu.Lock()
+	stored, ok := m.todos[todo.ID]
+	if !ok || stored.DeletedAt != nil || stored.Version != todo.Version {
+		m.mu.Unlock()
+		return false, nil
+	}
+	now := time.Now()
+	stored.DeletedAt = &now
+	m.todos[todo.ID] = stored
+	*todo = stored
+	m.mu.Unlock()
+
+	publish(events.Deleted, todo)
+	return true, nil
+}
+
+// Transition changes a todo unless it changed since it was read, records the
+// transition if given, and publishes the todo as updated
+func (m *memoryTodos) Transition(ctx context.Context, todo *models.Todo, changes map[string]interface{}, transition *models.TodoTransition) (bool, error) {
+	m.mu.Lock()
+	stored, ok := m.todos[todo.ID]
+	if !ok || stored.DeletedAt != nil || stored.Version != todo.Version {
+		m.mu.Unlock()
+		return false, nil
+	}
+	if err := applyTodoChanges(&stored, changes); err != nil {
+		m.mu.Unlock()
+		return false, err
+	}
+	stored.Version++
+	stored.UpdatedAt = time.Now()
+	m.todos[todo.ID] = stored
+	*todo = stored
+	if transition != nil {
+		m.lastTransitionID++
+		transition.ID = m.lastTransitionID
+		m.transitions = append(m.transitions, *transition)
+	}
+	m.mu.Unlock()
+
+	publish(events.Updated, todo)
+	return true, nil
+}
+
+// Transitions returns the transitions of a todo, oldest first
+func (m *memoryTodos) Transitions(ctx context.Context, todoID uint) ([]models.TodoTransition, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	transitions := []models.TodoTransition{}
+	for _, transition := range m.transitions {
+		if transition.TodoID == todoID {
+			transitions = append(transitions, transition)
+		}
+	}
+	return transitions, nil
+}
+
+// memoryTodoTypes is a TodoTypeRepository kept in memory
+type memoryTodoTypes struct {
+	*memoryStore
+}
+
+// List returns every todo type, ordered by position
+func (m *memoryTodoTypes) List(ctx context.Context) ([]models.TodoType, error) {
+	m.mu.RLock()
+	types := make([]models.TodoType, 0, len(m.todoTypes))
+	for _, t := range m.todoTypes {
+		types = append(types, t)
+	}
+	m.mu.RUnlock()
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].Position != types[j].Position {
+			return types[i].Position < types[j].Position
+		}
+		return types[i].ID < types[j].ID
+	})
+	return types, nil
+}
+
+// Find returns the todo type with a name
+func (m *memoryTodoTypes) Find(ctx context.Context, name string) (*models.TodoType, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, t := range m.todoTypes {
+		if t.Name == name {
+			return &t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Create saves a new todo type. Names are unique, as in the database.
+func (m *memoryTodoTypes) Create(ctx context.Context, todoType *models.TodoType) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.create(todoType)
+}
+
+// Update saves a todo type and renames its todos
+func (m *memoryTodoTypes) Update(ctx context.Context, todoType *models.TodoType, oldName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.todoTypes[todoType.ID]; !ok {
+		return ErrNotFound
+	}
+	for _, t := range m.todoTypes {
+		if t.ID != todoType.ID && t.Name == todoType.Name {
+			return fmt.Errorf("todo type %q already exists", todoType.Name)
+		}
+	}
+	if todoType.Name != oldName {
+		for id, todo := range m.todos {
+			if todo.Type == oldName {
+				todo.Type = todoType.Name
+				m.todos[id] = todo
+			}
+		}
+	}
+	todoType.UpdatedAt = time.Now()
+	m.todoTypes[todoType.ID] = *todoType
+	return nil
+}
+
+// Delete deletes a todo type
+func (m *memoryTodoTypes) Delete(ctx context.Context, todoType *models.TodoType) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.todoTypes, todoType.ID)
+	return nil
+}
+
+// Seed creates the default todo types if there are none yet
+func (m *memoryTodoTypes) Seed(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.todoTypes) > 0 {
+		return nil
+	}
+	for _, t := range models.DefaultTodoTypes {
+		todoType := t
+		if err := m.create(&todoType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// create saves a new todo type. The lock must be held.
+func (m *memoryTodoTypes) create(todoType *models.TodoType) error {
+	for _, t := range m.todoTypes {
+		if t.Name == todoType.Name {
+			return fmt.Errorf("todo type %q already exists", todoType.Name)
+		}
+	}
+	m.lastTodoTypeID++
+	todoType.ID = m.lastTodoTypeID
+	now := time.Now()
+	todoType.CreatedAt, todoType.UpdatedAt = now, now
+	m.todoTypes[todoType.ID] = *todoType
+	return nil
+}
+
+// matchTodo checks if a todo matches the conditions of a filter
+func matchTodo(todo models.Todo, filter models.TodoFilter) bool {
+	if todo.DeletedAt != nil {
+		return false
+	}
+	if filter.Query != "" && !strings.Contains(strings.ToLower(todo.Name), strings.ToLower(filter.Query)) {
+		return false
+	}
+	if filter.Type != "" && todo.Type != filter.Type {
+		return false
+	}
+	return filter.State == "" || todo.State == filter.State
+}
+
+// applyTodoChanges sets the todo fields named by the column names of a
+// change set
+func applyTodoChanges(todo *models.Todo, changes map[string]interface{}) error {
+	for column, value := range changes {
+		var err error
+		switch column {
+		case "name":
+			todo.Name, err = stringValue(column, value)
+		case "type":
+			todo.Type, err = stringValue(column, value)
+		case "state":
+			todo.State, err = stringValue(column, value)
+		case "moved_at":
+			todo.MovedAt, err = timeValue(column, value)
+		case "moved_by_id":
+			switch id := value.(type) {
+			case nil:
+				todo.MovedByID = nil
+			case uint:
+				todo.MovedByID = &id
+			default:
+				err = fmt.Errorf("invalid value for %s: %v", column, value)
+			}
+		case "version":
+			// Bumped by Transition
+		default:
+			err = fmt.Errorf("unknown todo column %q", column)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
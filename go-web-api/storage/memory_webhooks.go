@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// memoryWebhooks is a WebhookRepository kept in memory
+type memoryWebhooks struct {
+	*memoryStore
+}
+
+// List returns every webhook, ordered by ID
+func (m *memoryWebhooks) List(ctx context.Context) ([]models.Webhook, error) {
+	return m.listWhere(func(models.Webhook) bool { return true }), nil
+}
+
+// ListActive returns the active webhooks
+func (m *memoryWebhooks) ListActive(ctx context.Context) ([]models.Webhook, error) {
+	return m.listWhere(func(hook models.Webhook) bool { return hook.Active }), nil
+}
+
+// Find returns a webhook
+func (m *memoryWebhooks) Find(ctx context.Context, id uint) (*models.Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hook, ok := m.webhooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	hook = copyWebhook(hook)
+	return &hook, nil
+}
+
+// Create saves a new webhook
+func (m *memoryWebhooks) Create(ctx context.Context, hook *models.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastWebhookID++
+	hook.ID = m.lastWebhookID
+	now := time.Now()
+	hook.CreatedAt, hook.UpdatedAt = now, now
+	hook.EventFilter = strings.Join(hook.Events, ",")
+	m.webhooks[hook.ID] = copyWebhook(*hook)
+	return nil
+}
+
+// Update saves a webhook
+func (m *memoryWebhooks) Update(ctx context.Context, hook *models.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[hook.ID]; !ok {
+		return ErrNotFound
+	}
+	hook.UpdatedAt = time.Now()
+	hook.EventFilter = strings.Join(hook.Events, ",")
+	m.webhooks[hook.ID] = copyWebhook(*hook)
+	return nil
+}
+
+// Delete deletes a webhook and its deliveries
+func (m *memoryWebhooks) Delete(ctx context.Context, hook *models.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, delivery := range m.deliveries {
+		if delivery.WebhookID == hook.ID {
+			delete(m.deliveries, id)
+		}
+	}
+	delete(m.webhooks, hook.ID)
+	return nil
+}
+
+// FindDelivery returns a delivery from a webhook's log
+func (m *memoryWebhooks) FindDelivery(ctx context.Context, webhookID, id uint) (*models.WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	delivery, ok := m.deliveries[id]
+	if !ok || delivery.WebhookID != webhookID {
+		return nil, ErrNotFound
+	}
+	return &delivery, nil
+}
+
+// ListDeliveries returns a page of the deliveries matching a filter, most
+// recent first
+func (m *memoryWebhooks) ListDeliveries(ctx context.Context, filter models.DeliveryFilter) ([]models.WebhookDelivery, error) {
+	m.mu.RLock()
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range m.deliveries {
+		if matchDelivery(delivery, filter) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	offset := min(filter.Offset, len(deliveries))
+	deliveries = deliveries[offset:]
+	if filter.Limit > 0 && len(deliveries) > filter.Limit {
+		deliveries = deliveries[:filter.Limit]
+	}
+	return deliveries, nil
+}
+
+// CountDeliveries returns how many deliveries match a filter
+func (m *memoryWebhooks) CountDeliveries(ctx context.Context, filter models.DeliveryFilter) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	total := 0
+	for _, delivery := range m.deliveries {
+		if matchDelivery(delivery, filter) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// QueuedWebhooks returns the IDs of the webhooks an event was queued for
+func (m *memoryWebhooks) QueuedWebhooks(ctx context.Context, eventID string) ([]uint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var ids []uint
+	for _, delivery := range m.deliveries {
+		if delivery.EventID == eventID {
+			ids = append(ids, delivery.WebhookID)
+		}
+	}
+	return ids, nil
+}
+
+// CreateDelivery saves a new delivery
+func (m *memoryWebhooks) CreateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastDeliveryID++
+	delivery.ID = m.lastDeliveryID
+	now := time.Now()
+	delivery.CreatedAt, delivery.UpdatedAt = now, now
+	m.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+// DueDeliveries returns pending deliveries whose next attempt is due
+func (m *memoryWebhooks) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	m.mu.RLock()
+	due := []models.WebhookDelivery{}
+	for _, delivery := range m.deliveries {
+		if deliveryDue(delivery, now) {
+			due = append(due, delivery)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(due, func(i, j int) bool {
+		if c := due[i].NextAttemptAt.Compare(*due[j].NextAttemptAt); c != 0 {
+			return c < 0
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// ClaimDelivery moves the next attempt of a due delivery
+func (m *memoryWebhooks) ClaimDelivery(ctx context.Context, id uint, now, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery, ok := m.deliveries[id]
+	if !ok || !deliveryDue(delivery, now) {
+		return false, nil
+	}
+	delivery.NextAttemptAt = &until
+	m.deliveries[id] = delivery
+	return true, nil
+}
+
+// SaveDelivery saves the outcome of an attempt
+func (m *memoryWebhooks) SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.deliveries[delivery.ID]; !ok {
+		return ErrNotFound
+	}
+	delivery.UpdatedAt = time.Now()
+	m.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+// listWhere returns the webhooks that match, ordered by ID
+func (m *memoryWebhooks) listWhere(match func(models.Webhook) bool) []models.Webhook {
+	m.mu.RLock()
+	hooks := []models.Webhook{}
+	for _, hook := range m.webhooks {
+		if match(hook) {
+			hooks = append(hooks, copyWebhook(hook))
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+	return hooks
+}
+
+// copyWebhook returns a webhook with its own copy of the event filters, so
+// the caller cannot change the stored ones
+func copyWebhook(hook models.Webhook) models.Webhook {
+	hook.Events = append([]string{}, hook.Events...)
+	return hook
+}
+
+// matchDelivery checks if a delivery matches the conditions of a filter
+func matchDelivery(delivery models.WebhookDelivery, filter models.DeliveryFilter) bool {
+	return delivery.WebhookID == filter.WebhookID && (filter.Status == "" || delivery.Status == filter.Status)
+}
+
+// deliveryDue checks if a delivery is pending and its next attempt is due
+func deliveryDue(delivery models.WebhookDelivery, now time.Time) bool {
+	return delivery.Status == models.DeliveryPending && delivery.NextAttemptAt != nil && !delivery.NextAttemptAt.After(now)
+}
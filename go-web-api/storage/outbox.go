@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/events"
+)
+
+// CreateWithEvent creates a model and records the change in the outbox in
+// the same transaction
+func CreateWithEvent(db *gorm.DB, model interface{}, change string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(model).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, change, model)
+	})
+	if err != nil {
+		return err
+	}
+	events.Notify()
+	return nil
+}
+
+// UpdateWithEvent is UpdateVersioned that also records the change in the
+// outbox in the same transaction. Nothing is recorded if the update lost a
+// race.
+func UpdateWithEvent(db *gorm.DB, model interface{}, version uint, changes map[string]interface{}, change string) (bool, error) {
+	var saved bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if saved, err = UpdateVersioned(tx, model, version, changes); err != nil || !saved {
+			return err
+		}
+		return events.Record(tx, change, model)
+	})
+	if err != nil {
+		return false, err
+	}
+	if saved {
+		events.Notify()
+	}
+	return saved, nil
+}
+
+// DeleteWithEvent is deleteVersioned that also records the change in the
+// outbox in the same transaction. Nothing is recorded if the delete lost a
+// race.
+func DeleteWithEvent(db *gorm.DB, model interface{}, version uint, change string) (bool, error) {
+	var deleted bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if deleted, err = deleteVersioned(tx, model, version); err != nil || !deleted {
+			return err
+		}
+		return events.Record(tx, change, model)
+	})
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		events.Notify()
+	}
+	return deleted, nil
+}
+
+// UpdateVersioned applies changes to a versioned model only if the row still
+// has the version that was read, and bumps the version. It returns false if
+// the row was modified concurrently.
+func UpdateVersioned(db *gorm.DB, model interface{}, version uint, changes map[string]interface{}) (bool, error) {
+	changes["version"] = version + 1
+	result := db.Model(model).Where("version = ?", version).Updates(changes)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// deleteVersioned deletes a versioned model only if the row still has the
+// version that was read. It returns false if the row was modified concurrently.
+func deleteVersioned(db *gorm.DB, model interface{}, version uint) (bool, error) {
+	result := db.Where("version = ?", version).Delete(model)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
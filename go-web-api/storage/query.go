@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// applyItemFilter adds the conditions of an ItemFilter to a query. Ordering
+// and pagination are applied separately so the same query can be counted.
+func applyItemFilter(db *gorm.DB, filter models.ItemFilter) *gorm.DB {
+	if filter.Deleted {
+		db = db.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if filter.OwnerID != nil {
+		db = db.Where("owner_id = ?", *filter.OwnerID)
+	}
+	if filter.Query != "" {
+		pattern := "%" + EscapeLike(strings.ToLower(filter.Query)) + "%"
+		db = db.Where(`LOWER(title) LIKE ? ESCAPE '\' OR LOWER(description) LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+	if filter.Type != "" {
+		db = db.Where("type = ?", filter.Type)
+	}
+	if filter.Moved != nil {
+		if *filter.Moved {
+			db = db.Where("moved_at IS NOT NULL")
+		} else {
+			db = db.Where("moved_at IS NULL")
+		}
+	}
+	if filter.MinPrice != nil {
+		db = db.Where("price >= ?", *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		db = db.Where("price <= ?", *filter.MaxPrice)
+	}
+	if filter.CreatedAfter != nil {
+		db = db.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *filter.CreatedBefore)
+	}
+	return db
+}
+
+// applyUserFilter adds the conditions of a UserFilter to a query
+func applyUserFilter(db *gorm.DB, filter models.UserFilter) *gorm.DB {
+	if filter.Deleted {
+		db = db.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if filter.Role != "" {
+		db = db.Where("role = ?", filter.Role)
+	}
+	return db
+}
+
+// applyTodoFilter adds the conditions of a TodoFilter to a query
+func applyTodoFilter(db *gorm.DB, filter models.TodoFilter) *gorm.DB {
+	if filter.Query != "" {
+		pattern := "%" + EscapeLike(strings.ToLower(filter.Query)) + "%"
+		db = db.Where(`LOWER(name) LIKE ? ESCAPE '\'`, pattern)
+	}
+	if filter.Type != "" {
+		db = db.Where("type = ?", filter.Type)
+	}
+	if filter.State != "" {
+		db = db.Where("state = ?", filter.State)
+	}
+	return db
+}
+
+// Paginate orders a query by the sort fields and selects the page after the
+// keyset values if given, otherwise after the offset
+func Paginate(db *gorm.DB, fields []models.SortField, after []interface{}, limit, offset int) *gorm.DB {
+	if after != nil {
+		condition, args := KeysetCondition(fields, after)
+		db = db.Where(condition, args...)
+	} else if offset > 0 {
+		db = db.Offset(offset)
+	}
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+	return ApplySort(db, fields)
+}
+
+// ApplySort orders a query by the given fields
+func ApplySort(db *gorm.DB, fields []models.SortField) *gorm.DB {
+	for _, field := range fields {
+		if field.Desc {
+			db = db.Order(field.Field + " DESC")
+		} else {
+			db = db.Order(field.Field + " ASC")
+		}
+	}
+	return db
+}
+
+// KeysetCondition builds the WHERE clause selecting the rows that come after
+// the given sort key values in the given order:
+// (a > ?) OR (a = ? AND b > ?) OR ...
+func KeysetCondition(fields []models.SortField, values []interface{}) (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+
+	for i, field := range fields {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, fields[j].Field+" = ?")
+			args = append(args, values[j])
+		}
+		op := ">"
+		if field.Desc {
+			op = "<"
+		}
+		parts = append(parts, field.Field+" "+op+" ?")
+		args = append(args, values[i])
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+
+	return strings.Join(clauses, " OR "), args
+}
+
+// EscapeLike escapes the LIKE wildcards in s
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// ErrNotFound is returned when a row does not exist, or is not where it was
+// looked for: in the trash or out of it
+var ErrNotFound = errors.New("not found")
+
+// Store holds the repositories handed to handlers and jobs. Every table the
+// API serves is behind it, so the memory store needs no database. Only the
+// database store keeps an event outbox; the memory store publishes changes
+// as it makes them.
+type Store struct {
+	Items     ItemRepository
+	Users     UserRepository
+	Roles     RoleRepository
+	Tokens    TokenRepository
+	Todos     TodoRepository
+	TodoTypes TodoTypeRepository
+	Webhooks  WebhookRepository
+}
+
+// ItemRepository stores items. Every change is published as an item event.
+type ItemRepository interface {
+	// Find returns an item that is not in the trash
+	Find(ctx context.Context, id uint) (*models.Item, error)
+	// FindDeleted returns an item that is in the trash
+	FindDeleted(ctx context.Context, id uint) (*models.Item, error)
+	// List returns a page of the items matching a filter, in its sort order,
+	// after its keyset values if set, otherwise after its offset
+	List(ctx context.Context, filter models.ItemFilter) ([]models.Item, error)
+	// Count returns how many items match a filter, ignoring its pagination
+	Count(ctx context.Context, filter models.ItemFilter) (int, error)
+	// Create saves a new item at the first version
+	Create(ctx context.Context, item *models.Item) error
+	// Update applies changes, keyed by column name, unless the item changed
+	// since it was read, and bumps the version. It returns false if the item
+	// was modified concurrently.
+	Update(ctx context.Context, item *models.Item, changes map[string]interface{}) (bool, error)
+	// Delete moves an item to the trash unless it changed since it was read
+	Delete(ctx context.Context, item *models.Item) (bool, error)
+	// Restore moves an item out of the trash unless it changed since it was
+	// read. An expiry time that has passed is cleared, so the item is not
+	// expired again.
+	Restore(ctx context.Context, item *models.Item) (bool, error)
+	// Purge permanently deletes items, whether or not they are in the trash
+	Purge(ctx context.Context, ids []uint) error
+	// PurgeTrash permanently deletes the items moved to the trash before the
+	// cutoff and returns how many were purged
+	PurgeTrash(ctx context.Context, cutoff time.Time) (int, error)
+	// Expire returns the moved items whose return time has passed and moves
+	// the items whose expiry time has passed to the trash
+	Expire(ctx context.Context, now time.Time) (returned, expired []models.Item, err error)
+}
+
+// UserRepository stores users. Every change is published as a user event.
+type UserRepository interface {
+	// Find returns a user that is not in the trash
+	Find(ctx context.Context, id uint) (*models.User, error)
+	// FindDeleted returns a user that is in the trash
+	FindDeleted(ctx context.Context, id uint) (*models.User, error)
+	// FindByUsername returns the user with a username, unless in the trash
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	// FindByEmail returns the user with an email, even in the trash, as
+	// emails stay taken until their users are purged
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	// FindByUsernameOrEmail returns a user with either the username or the
+	// email, even in the trash, as both stay taken until the user is purged
+	FindByUsernameOrEmail(ctx context.Context, username, email string) (*models.User, error)
+	// List returns a page of the users matching a filter, in its sort order,
+	// after its keyset values if set, otherwise after its offset
+	List(ctx context.Context, filter models.UserFilter) ([]models.User, error)
+	// Count returns how many users match a filter, ignoring its pagination
+	Count(ctx context.Context, filter models.UserFilter) (int, error)
+	// Create hashes the password and saves a new user at the first version
+	Create(ctx context.Context, user *models.User) error
+	// Update applies changes, keyed by column name, unless the user changed
+	// since it was read, and bumps the version. It returns false if the user
+	// was modified concurrently.
+	Update(ctx context.Context, user *models.User, changes map[string]interface{}) (bool, error)
+	// Delete moves a user to the trash unless it changed since it was read
+	Delete(ctx context.Context, user *models.User) (bool, error)
+	// Restore moves a user out of the trash unless it changed since it was
+	// read
+	Restore(ctx context.Context, user *models.User) (bool, error)
+	// Purge permanently deletes users together with everything they own
+	Purge(ctx context.Context, ids []uint) error
+	// PurgeTrash permanently deletes the users moved to the trash before the
+	// cutoff, like Purge, and returns how many were purged
+	PurgeTrash(ctx context.Context, cutoff time.Time) (int, error)
+	// RevokeTokens moves a user's token revocation cutoff, even in the trash
+	RevokeTokens(ctx context.Context, id uint, at time.Time) error
+}
+
+// RoleRepository stores roles and the permissions they grant
+type RoleRepository interface {
+	// Permissions returns every permission, ordered by name
+	Permissions(ctx context.Context) ([]models.Permission, error)
+	// FindPermissions returns those of the named permissions that exist
+	FindPermissions(ctx context.Context, names []string) ([]models.Permission, error)
+	// List returns every role with its permissions, ordered by name
+	List(ctx context.Context) ([]models.Role, error)
+	// Find returns the role with a name, with its permissions
+	Find(ctx context.Context, name string) (*models.Role, error)
+	// Create saves a new role with its permissions
+	Create(ctx context.Context, role *models.Role) error
+	// Update saves the description of a role and replaces its permissions
+	Update(ctx context.Context, role *models.Role) error
+	// Delete deletes a role
+	Delete(ctx context.Context, role *models.Role) error
+	// Seed makes sure every known permission exists, that the admin role
+	// holds all of them, and that the default user role exists
+	Seed(ctx context.Context) error
+}
+
+// TokenRepository stores refresh tokens and revoked access tokens
+type TokenRepository interface {
+	// CreateRefreshToken saves a new refresh token
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	// FindRefreshToken returns the refresh token with a hash
+	FindRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error)
+	// UseRefreshToken marks a refresh token as used. It returns false if the
+	// token was used already, so two concurrent rotations cannot both
+	// succeed.
+	UseRefreshToken(ctx context.Context, id uint, at time.Time) (bool, error)
+	// RevokeFamily revokes every refresh token of a family
+	RevokeFamily(ctx context.Context, familyID string, at time.Time) error
+	// RevokeRefreshTokens revokes every refresh token of a user
+	RevokeRefreshTokens(ctx context.Context, userID uint, at time.Time) error
+	// IsRevoked reports whether the access token with an ID was revoked
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// Revoke adds an access token to the revoked list, dropping the entries
+	// of tokens that have expired on their own
+	Revoke(ctx context.Context, token *models.RevokedToken) error
+}
+
+// TodoRepository stores todos and their transitions. Every change is
+// published as a todo event.
+type TodoRepository interface {
+	// Find returns a todo
+	Find(ctx context.Context, id uint) (*models.Todo, error)
+	// List returns a page of the todos matching a filter, in its sort order,
+	// after its keyset values if set, otherwise after its offset
+	List(ctx context.Context, filter models.TodoFilter) ([]models.Todo, error)
+	// Count returns how many todos match a filter, ignoring its pagination
+	Count(ctx context.Context, filter models.TodoFilter) (int, error)
+	// ListState returns the todos in a state in the order they arrived in
+	// it: by update time in the main list and by move time once moved
+	ListState(ctx context.Context, state string) ([]models.Todo, error)
+	// Create saves a new todo in the main list at the first version
+	Create(ctx context.Context, todo *models.Todo) error
+	// Update applies changes, keyed by column name, unless the todo changed
+	// since it was read, and bumps the version. It returns false if the todo
+	// was modified concurrently.
+	Update(ctx context.Context, todo *models.Todo, changes map[string]interface{}) (bool, error)
+	// Delete deletes a todo unless it changed since it was read
+	Delete(ctx context.Context, todo *models.Todo) (bool, error)
+	// Transition is Update that also records the transition moving the todo
+	Transition(ctx context.Context, todo *models.Todo, changes map[string]interface{}, transition *models.TodoTransition) (bool, error)
+	// Transitions returns the transitions of a todo, oldest first
+	Transitions(ctx context.Context, todoID uint) ([]models.TodoTransition, error)
+}
+
+// TodoTypeRepository stores the columns of the todo board
+type TodoTypeRepository interface {
+	// List returns every todo type, ordered by position
+	List(ctx context.Context) ([]models.TodoType, error)
+	// Find returns the todo type with a name
+	Find(ctx context.Context, name string) (*models.TodoType, error)
+	// Create saves a new todo type
+	Create(ctx context.Context, todoType *models.TodoType) error
+	// Update saves a todo type. If it was renamed from oldName, the todos of
+	// the type, even deleted ones, are renamed with it.
+	Update(ctx context.Context, todoType *models.TodoType, oldName string) error
+	// Delete deletes a todo type
+	Delete(ctx context.Context, todoType *models.TodoType) error
+	// Seed creates the default todo types if there are none yet
+	Seed(ctx context.Context) error
+}
+
+// WebhookRepository stores webhooks and their delivery log
+type WebhookRepository interface {
+	// List returns every webhook, ordered by ID
+	List(ctx context.Context) ([]models.Webhook, error)
+	// ListActive returns the active webhooks
+	ListActive(ctx context.Context) ([]models.Webhook, error)
+	// Find returns a webhook
+	Find(ctx context.Context, id uint) (*models.Webhook, error)
+	// Create saves a new webhook
+	Create(ctx context.Context, hook *models.Webhook) error
+	// Update saves a webhook
+	Update(ctx context.Context, hook *models.Webhook) error
+	// Delete deletes a webhook along with its delivery log
+	Delete(ctx context.Context, hook *models.Webhook) error
+	// FindDelivery returns a delivery from a webhook's log
+	FindDelivery(ctx context.Context, webhookID, id uint) (*models.WebhookDelivery, error)
+	// ListDeliveries returns a page of the deliveries matching a filter, most
+	// recent first
+	ListDeliveries(ctx context.Context, filter models.DeliveryFilter) ([]models.WebhookDelivery, error)
+	// CountDeliveries returns how many deliveries match a filter, ignoring
+	// its pagination
+	CountDeliveries(ctx context.Context, filter models.DeliveryFilter) (int, error)
+	// QueuedWebhooks returns the IDs of the webhooks an event was queued for
+	QueuedWebhooks(ctx context.Context, eventID string) ([]uint, error)
+	// CreateDelivery saves a new delivery
+	CreateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	// DueDeliveries returns up to limit pending deliveries whose next
+	// attempt is due, the longest waiting first
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	// ClaimDelivery moves the next attempt of a due delivery to until, so
+	// other instances skip it while it is sent. It returns false if the
+	// delivery is no longer due.
+	ClaimDelivery(ctx context.Context, id uint, now, until time.Time) (bool, error)
+	// SaveDelivery saves the outcome of an attempt
+	SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+}
+
+// New returns the store selected by STORAGE: the database, or memory. The
+// database is only used by the database store, and may be nil otherwise.
+func New(cfg *config.Config, db *gorm.DB) (*Store, error) {
+	switch cfg.Storage {
+	case "database":
+		return NewGormStore(db), nil
+	case "memory":
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown storage %q, expected database or memory", cfg.Storage)
+}
+
+// restoreChanges returns the changes restoring an item, which clear an expiry
+// time that has passed
+func restoreChanges(item *models.Item, now time.Time) map[string]interface{} {
+	changes := map[string]interface{}{"deleted_at": nil}
+	if item.ExpiresAt != nil && !item.ExpiresAt.After(now) {
+		changes["expires_at"] = nil
+	}
+	return changes
+}
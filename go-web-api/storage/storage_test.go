@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/models"
+)
+
+// testStores returns a memory store and a store backed by a migrated
+// in-memory SQLite database, to run a test against both
+func testStores(t *testing.T) map[string]*Store {
+	t.Helper()
+	cfg := &config.Config{DBDriver: "sqlite", DBPath: ":memory:", DBConnectAttempts: 1}
+	if err := database.Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if _, err := database.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*Store{
+		"database": NewGormStore(database.DB),
+		"memory":   NewMemoryStore(),
+	}
+}
+
+func TestRestoreExpiredItem(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			expiresAt := time.Now().Add(-time.Hour)
+			item := models.Item{Title: "Expired", ExpiresAt: &expiresAt}
+			if err := store.Items.Create(ctx, &item); err != nil {
+				t.Fatal(err)
+			}
+
+			// Expire the item and restore it
+			if _, expired, err := store.Items.Expire(ctx, time.Now()); err != nil || len(expired) != 1 {
+				t.Fatalf("Expire() = %v, %v, want the item expired", expired, err)
+			}
+			deleted, err := store.Items.FindDeleted(ctx, item.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved, err := store.Items.Restore(ctx, deleted); err != nil || !saved {
+				t.Fatalf("Restore() = %v, %v, want it saved", saved, err)
+			}
+
+			// The next expiry pass leaves it alone
+			if _, expired, err := store.Items.Expire(ctx, time.Now()); err != nil || len(expired) != 0 {
+				t.Fatalf("Expire() = %v, %v, want nothing expired", expired, err)
+			}
+			restored, err := store.Items.Find(ctx, item.ID)
+			if err != nil {
+				t.Fatalf("Find() after restoring = %v", err)
+			}
+			if restored.ExpiresAt != nil {
+				t.Errorf("ExpiresAt = %v after restoring, want it cleared", restored.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestRestoreKeepsFutureExpiry(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			expiresAt := time.Now().Add(time.Hour)
+			item := models.Item{Title: "Expiring", ExpiresAt: &expiresAt}
+			if err := store.Items.Create(ctx, &item); err != nil {
+				t.Fatal(err)
+			}
+
+			// Delete the item and restore it
+			if saved, err := store.Items.Delete(ctx, &item); err != nil || !saved {
+				t.Fatalf("Delete() = %v, %v, want it saved", saved, err)
+			}
+			deleted, err := store.Items.FindDeleted(ctx, item.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved, err := store.Items.Restore(ctx, deleted); err != nil || !saved {
+				t.Fatalf("Restore() = %v, %v, want it saved", saved, err)
+			}
+
+			restored, err := store.Items.Find(ctx, item.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored.ExpiresAt == nil || !restored.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("ExpiresAt = %v after restoring, want %v", restored.ExpiresAt, expiresAt)
+			}
+		})
+	}
+}
+
+func TestUpdateRolePermissions(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.Roles.Seed(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Roles.Seed(ctx); err != nil {
+				t.Fatalf("Seed() again = %v", err)
+			}
+
+			// Replace the permissions of the user role
+			role, err := store.Roles.Find(ctx, models.RoleUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(role.Permissions) != len(models.DefaultUserPermissions) {
+				t.Fatalf("user role has %d permissions, want %d", len(role.Permissions), len(models.DefaultUserPermissions))
+			}
+			role.Permissions, err = store.Roles.FindPermissions(ctx, []string{models.PermTodosRead})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Roles.Update(ctx, role); err != nil {
+				t.Fatal(err)
+			}
+
+			role, err = store.Roles.Find(ctx, models.RoleUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(role.Permissions) != 1 || role.Permissions[0].Name != models.PermTodosRead {
+				t.Errorf("permissions after updating = %+v, want only todos:read", role.Permissions)
+			}
+		})
+	}
+}
+
+func TestTransitionTodo(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			todo := models.Todo{Name: "Apple", Type: "Fruit", CreatedByID: 1}
+			if err := store.Todos.Create(ctx, &todo); err != nil {
+				t.Fatal(err)
+			}
+
+			// Move the todo, then try again with the version it was read at
+			stale := todo
+			now := time.Now()
+			changes := map[string]interface{}{"state": models.TodoStateMoved, "moved_at": now, "moved_by_id": uint(1)}
+			transition := models.TodoTransition{TodoID: todo.ID, Action: models.TodoActionMove, FromState: models.TodoStateMain, ToState: models.TodoStateMoved, Type: todo.Type, UserID: 1, CreatedAt: now}
+			if saved, err := store.Todos.Transition(ctx, &todo, changes, &transition); err != nil || !saved {
+				t.Fatalf("Transition() = %v, %v, want it saved", saved, err)
+			}
+			again := transition
+			again.ID = 0
+			if saved, err := store.Todos.Transition(ctx, &stale, changes, &again); err != nil || saved {
+				t.Fatalf("Transition() of a stale todo = %v, %v, want it refused", saved, err)
+			}
+
+			moved, err := store.Todos.ListState(ctx, models.TodoStateMoved)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(moved) != 1 || moved[0].Version != 2 || moved[0].MovedByID == nil || *moved[0].MovedByID != 1 {
+				t.Errorf("moved todos = %+v, want the todo moved by user 1 at version 2", moved)
+			}
+			transitions, err := store.Todos.Transitions(ctx, todo.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(transitions) != 1 {
+				t.Errorf("%d transitions recorded, want 1", len(transitions))
+			}
+		})
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			token := models.RefreshToken{UserID: 1, TokenHash: "hash", FamilyID: "family", ExpiresAt: time.Now().Add(time.Hour)}
+			if err := store.Tokens.CreateRefreshToken(ctx, &token); err != nil {
+				t.Fatal(err)
+			}
+
+			// A token is used once only
+			if used, err := store.Tokens.UseRefreshToken(ctx, token.ID, time.Now()); err != nil || !used {
+				t.Fatalf("UseRefreshToken() = %v, %v, want it used", used, err)
+			}
+			if used, err := store.Tokens.UseRefreshToken(ctx, token.ID, time.Now()); err != nil || used {
+				t.Fatalf("UseRefreshToken() again = %v, %v, want it refused", used, err)
+			}
+
+			// Revoking the family revokes the token
+			if err := store.Tokens.RevokeFamily(ctx, token.FamilyID, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := store.Tokens.FindRefreshToken(ctx, "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.UsedAt == nil || stored.RevokedAt == nil {
+				t.Errorf("token = %+v, want it used and revoked", stored)
+			}
+			if _, err := store.Tokens.FindRefreshToken(ctx, "other"); err != ErrNotFound {
+				t.Errorf("FindRefreshToken() of an unknown token = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestClaimDueDelivery(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			hook := models.Webhook{URL: "https://example.com/hook", Secret: "secret", Events: []string{"*"}, Active: true}
+			if err := store.Webhooks.Create(ctx, &hook); err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			delivery := models.WebhookDelivery{WebhookID: hook.ID, EventID: "evt_1", EventType: "item.created", Payload: "{}", Status: models.DeliveryPending, NextAttemptAt: &now}
+			if err := store.Webhooks.CreateDelivery(ctx, &delivery); err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the first claim of a due delivery succeeds
+			due, err := store.Webhooks.DueDeliveries(ctx, now, 10)
+			if err != nil || len(due) != 1 {
+				t.Fatalf("DueDeliveries() = %v, %v, want the delivery", due, err)
+			}
+			until := now.Add(time.Minute)
+			if claimed, err := store.Webhooks.ClaimDelivery(ctx, delivery.ID, now, until); err != nil || !claimed {
+				t.Fatalf("ClaimDelivery() = %v, %v, want it claimed", claimed, err)
+			}
+			if claimed, err := store.Webhooks.ClaimDelivery(ctx, delivery.ID, now, until); err != nil || claimed {
+				t.Fatalf("ClaimDelivery() again = %v, %v, want it refused", claimed, err)
+			}
+			if due, err := store.Webhooks.DueDeliveries(ctx, now, 10); err != nil || len(due) != 0 {
+				t.Fatalf("DueDeliveries() after claiming = %v, %v, want none", due, err)
+			}
+
+			// Deleting the webhook deletes its log
+			if err := store.Webhooks.Delete(ctx, &hook); err != nil {
+				t.Fatal(err)
+			}
+			if count, err := store.Webhooks.CountDeliveries(ctx, models.DeliveryFilter{WebhookID: hook.ID}); err != nil || count != 0 {
+				t.Errorf("CountDeliveries() after deleting = %d, %v, want 0", count, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/database"
+	"github.com/niphawanphoopha/go-web-api/middleware"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// userUsage describes the user command
+const userUsage = `Usage: go-web-api user <command>
+
+Passwords are read from standard input, so they stay out of the shell
+history and process listings.
+
+Commands:
+  create [--admin] <username> <email>  Create a user, with the admin role
+                                       if --admin is given
+  reset-password <username>            Set a user's password and revoke
+                                       their tokens
+`
+
+// runUser runs the user command and returns the exit code
+func runUser(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("user", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	admin := fs.Bool("admin", false, "")
+	args, err := parseArgs(fs, args)
+	if err != nil || len(args) == 0 {
+		fmt.Fprint(os.Stderr, userUsage)
+		return 2
+	}
+	switch {
+	case args[0] == "create" && len(args) == 3:
+	case args[0] == "reset-password" && len(args) == 2 && !*admin:
+	default:
+		fmt.Fprint(os.Stderr, userUsage)
+		return 2
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read password: %v\n", err)
+		return 1
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open storage: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	if args[0] == "create" {
+		return createUser(store, args[1], args[2], password, *admin)
+	}
+	return resetPassword(store, args[1], password)
+}
+
+// createUser creates a user with the user role, or the admin role
+func createUser(store *storage.Store, username, email, password string, admin bool) int {
+	ctx := context.Background()
+
+	// Check if username or email already exists
+	_, err := store.Users.FindByUsernameOrEmail(ctx, username, email)
+	if err == nil {
+		fmt.Fprintln(os.Stderr, "Username or email already exists")
+		return 1
+	}
+	if err != storage.ErrNotFound {
+		fmt.Fprintf(os.Stderr, "Failed to create user: %v\n", err)
+		return 1
+	}
+
+	// Save user to the store
+	user := models.User{
+		Username: username,
+		Email:    email,
+		Password: password, // Will be hashed by the store
+		Role:     models.RoleUser,
+	}
+	if admin {
+		user.Role = models.RoleAdmin
+	}
+	if err := store.Users.Create(ctx, &user); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create user: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Created user %d: %s with role %s\n", user.ID, user.Username, user.Role)
+	return 0
+}
+
+// resetPassword sets a user's password and revokes every token issued to
+// them, so sessions opened with the old password end
+func resetPassword(store *storage.Store, username, password string) int {
+	ctx := context.Background()
+
+	// Find the user in the store
+	user, err := store.Users.FindByUsername(ctx, username)
+	if err == storage.ErrNotFound {
+		fmt.Fprintf(os.Stderr, "No user %s\n", username)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find user: %v\n", err)
+		return 1
+	}
+
+	// Store the hash, which the stores keep as is
+	hashedPassword, err := models.HashPassword(password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to hash password: %v\n", err)
+		return 1
+	}
+	saved, err := store.Users.Update(ctx, user, map[string]interface{}{"password": hashedPassword})
+	if err == nil && !saved {
+		err = errors.New("user was modified concurrently, try again")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to reset password: %v\n", err)
+		return 1
+	}
+
+	// Revoke the tokens issued with the old password
+	if err := middleware.NewRevocationStore(store).RevokeUser(user.ID); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to revoke user tokens: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Reset the password of user %d: %s\n", user.ID, user.Username)
+	return 0
+}
+
+// readPassword reads a password from the first line of standard input
+func readPassword() (string, error) {
+	password, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return "", errors.New("no password given on standard input")
+	}
+	return password, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+)
+
+// vaultUsage describes the vault command
+const vaultUsage = `Usage: go-web-api vault <command>
+
+Manages the encrypted vault at SECRETS_VAULT, opened with SECRETS_VAULT_KEY.
+
+Commands:
+  set <key>     Store a secret, e.g. JWT_SECRET, read from standard input
+  delete <key>  Remove a secret
+  list          List the keys of the stored secrets
+`
+
+// runVault runs the vault command and returns the exit code
+func runVault(cfg *config.Config, args []string) int {
+	if len(args) == 0 || (args[0] == "list") != (len(args) == 1) {
+		fmt.Fprint(os.Stderr, vaultUsage)
+		return 2
+	}
+
+	vault, err := config.NewVaultProvider(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open vault: %v\n", err)
+		return 1
+	}
+	secrets, err := vault.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open vault: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		keys := make([]string, 0, len(secrets))
+		for key := range secrets {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		return 0
+
+	case "set":
+		// Read the secret from standard input, so it stays out of the
+		// shell history and process listings
+		value, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		value = strings.TrimRight(value, "\r\n")
+		if value == "" {
+			fmt.Fprintln(os.Stderr, "No secret given on standard input")
+			return 1
+		}
+		secrets[args[1]] = value
+
+	case "delete":
+		if _, ok := secrets[args[1]]; !ok {
+			fmt.Fprintf(os.Stderr, "No secret %s in the vault\n", args[1])
+			return 1
+		}
+		delete(secrets, args[1])
+
+	default:
+		fmt.Fprint(os.Stderr, vaultUsage)
+		return 2
+	}
+
+	if err := vault.Save(secrets); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save vault: %v\n", err)
+		return 1
+	}
+	return 0
+}
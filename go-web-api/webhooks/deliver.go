@@ -0,0 +1,172 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/config"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+const (
+	// batchSize is the most deliveries attempted per run
+	batchSize = 100
+	// workers is the most deliveries attempted at the same time
+	workers = 8
+	// maxBackoff caps the delay between two attempts
+	maxBackoff = time.Hour
+	// maxResponseBody is how much of a response is kept in the delivery log
+	maxResponseBody = 1024
+)
+
+// Dispatcher sends due webhook deliveries and schedules retries
+type Dispatcher struct {
+	hooks       storage.WebhookRepository
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewDispatcher returns a dispatcher configured from the webhook settings,
+// sending the deliveries queued in the webhook repository
+func NewDispatcher(cfg *config.Config, hooks storage.WebhookRepository) *Dispatcher {
+	return &Dispatcher{
+		hooks: hooks,
+		client: &http.Client{
+			Timeout: time.Duration(cfg.WebhookTimeout) * time.Second,
+			// A redirect is reported as a failed delivery rather than followed
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxAttempts: cfg.WebhookMaxAttempts,
+		backoff:     time.Duration(cfg.WebhookRetryBackoff) * time.Second,
+	}
+}
+
+// DeliverDue attempts every pending delivery whose next attempt is due and
+// returns how many were attempted
+func (d *Dispatcher) DeliverDue(now time.Time) (int, error) {
+	ctx := context.Background()
+	due, err := d.hooks.DueDeliveries(ctx, now, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
+	attempted := 0
+	for i := range due {
+		// Claim the delivery so other instances skip it while it is sent
+		lease := now.Add(d.client.Timeout + time.Minute)
+		claimed, err := d.hooks.ClaimDelivery(ctx, due[i].ID, now, lease)
+		if err != nil {
+			return attempted, err
+		}
+		if !claimed {
+			continue
+		}
+		attempted++
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(delivery *models.WebhookDelivery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			d.attempt(delivery)
+		}(&due[i])
+	}
+	wg.Wait()
+	return attempted, nil
+}
+
+// attempt sends a delivery once and records the outcome: success, a retry
+// after an exponential backoff, or failure once the attempts are used up
+func (d *Dispatcher) attempt(delivery *models.WebhookDelivery) {
+	code, body, err := d.send(delivery)
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	delivery.ResponseBody = body
+	delivery.Error = ""
+	delivery.LastAttemptAt = &now
+	delivery.NextAttemptAt = nil
+	switch {
+	case err == nil && code >= 200 && code < 300:
+		delivery.Status = models.DeliverySucceeded
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = models.DeliveryFailed
+	default:
+		next := now.Add(d.retryDelay(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	} else if code < 200 || code >= 300 {
+		delivery.Error = fmt.Sprintf("unexpected status %d", code)
+	}
+
+	if err := d.hooks.SaveDelivery(context.Background(), delivery); err != nil {
+		log.Printf("Failed to save webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// send posts the signed payload to the webhook and returns the response
+// status and the start of the response body
+func (d *Dispatcher) send(delivery *models.WebhookDelivery) (int, string, error) {
+	// Find the webhook, which may have been disabled since the event
+	hook, err := d.hooks.Find(context.Background(), delivery.WebhookID)
+	if err == storage.ErrNotFound || (err == nil && !hook.Active) {
+		return 0, "", fmt.Errorf("webhook is not active")
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	// Sign the payload
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-web-api-webhooks")
+	req.Header.Set("X-Webhook-ID", delivery.EventID)
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(hook.Secret, timestamp, body))
+
+	// Send it and keep the start of the response
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	return resp.StatusCode, strings.ToValidUTF8(string(response), ""), nil
+}
+
+// retryDelay returns how long to wait after the given number of attempts,
+// doubling each time up to maxBackoff
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,146 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/niphawanphoopha/go-web-api/events"
+	"github.com/niphawanphoopha/go-web-api/models"
+	"github.com/niphawanphoopha/go-web-api/storage"
+)
+
+// Payload is the JSON body posted to webhooks
+type Payload struct {
+	ID        string          `json:"id"` // Same for every delivery of the event
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Enqueuer returns the event publisher that queues deliveries in the
+// webhook repository with Enqueue. It is registered as an outbox publisher.
+func Enqueuer(hooks storage.WebhookRepository) events.Publisher {
+	return func(event events.Event) error {
+		return Enqueue(context.Background(), hooks, event)
+	}
+}
+
+// Enqueue records a pending delivery of an event to every active webhook
+// whose filters match it, skipping webhooks it was already queued for, so a
+// relayed event is delivered once even if the relay repeats it
+func Enqueue(ctx context.Context, hooks storage.WebhookRepository, event events.Event) error {
+	// Find the webhooks that want the event
+	active, err := hooks.ListActive(ctx)
+	if err != nil {
+		return err
+	}
+	var matched []models.Webhook
+	for _, hook := range active {
+		if Matches(&hook, event.Type) {
+			matched = append(matched, hook)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+
+	// Skip the webhooks the event was queued for before
+	queued, err := hooks.QueuedWebhooks(ctx, event.EventID)
+	if err != nil {
+		return err
+	}
+
+	// Encode the payload once for every webhook
+	payload, err := json.Marshal(Payload{ID: event.EventID, Type: event.Type, CreatedAt: event.CreatedAt, Data: event.Data})
+	if err != nil {
+		return err
+	}
+
+	// Queue a delivery to each webhook
+	now := time.Now()
+	for _, hook := range matched {
+		if containsID(queued, hook.ID) {
+			continue
+		}
+		delivery := models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			EventID:       event.EventID,
+			EventType:     event.Type,
+			Payload:       string(payload),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: &now,
+		}
+		if err := hooks.CreateDelivery(ctx, &delivery); err != nil {
+			return fmt.Errorf("queue for webhook %d: %v", hook.ID, err)
+		}
+	}
+	return nil
+}
+
+// Redeliver queues a new delivery of the same event, so the log keeps the
+// outcome of the original one
+func Redeliver(ctx context.Context, hooks storage.WebhookRepository, delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	now := time.Now()
+	redelivery := models.WebhookDelivery{
+		WebhookID:     delivery.WebhookID,
+		EventID:       delivery.EventID,
+		EventType:     delivery.EventType,
+		Payload:       delivery.Payload,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: &now,
+	}
+	if err := hooks.CreateDelivery(ctx, &redelivery); err != nil {
+		return nil, err
+	}
+	return &redelivery, nil
+}
+
+// Matches checks if any of a webhook's filters matches an event type
+func Matches(hook *models.Webhook, eventType string) bool {
+	for _, filter := range hook.Events {
+		if events.Match(filter, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the signature sent in the X-Webhook-Signature header: the
+// hex-encoded HMAC-SHA256 of the timestamp, a dot and the body, keyed with the
+// webhook's secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates a random webhook signing secret
+func NewSecret() (string, error) {
+	return randomID("whsec_")
+}
+
+// containsID checks if a list of IDs contains an ID
+func containsID(ids []uint, id uint) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// randomID returns the prefix followed by 32 random hex digits
+func randomID(prefix string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}